@@ -0,0 +1,213 @@
+// Command nettingctl runs the netting chaincode locally, in a contract.MockCallerStub, without a Fabric network.
+// The state is kept in a file, in the format of contract.FileStore, between the runs. Transactions take
+// place now and are signed with the -caller certificate, init makes its holder the admin.
+//
+//	nettingctl [-state netting.json] [-caller admin] [-v] command [args...]
+//
+// Arguments are passed to the chaincode function as they are, see "nettingctl help".
+// Scenario files (see contract.Scenario) are replayed on a new chaincode, not on the state:
+//
+//	nettingctl replay scenario.txt...
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/VladimirStarostenkov/netting_chaincode/contract"
+	"github.com/op/go-logging"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A chaincode function run by a subcommand
+type command struct {
+	function string
+	query    bool
+	usage    string
+}
+
+var commands map[string]command = map[string]command{
+	"init":          {"init", false, "[Config json]"},
+	"add-party":     {"AddCounterParty", false, ""},
+	"add-claim":     {"AddClaim", false, "From To Value [IdempotencyKey [Class]]"},
+	"cancel-claim":  {"CancelClaim", false, "From To Value [Class]"},
+	"close-cycle":   {"CloseCycle", false, ""},
+	"run-netting":   {"RunNetting", false, "[Currency [ValueDate [Algorithm [Participants [Threshold]]]]]"},
+	"submit-result": {"SubmitNettingResult", false, "Graph json [Currency [ValueDate]]"},
+	"confirm":       {"ConfirmSettlement", false, "InstructionId CounterPartyId"},
+	"fail":          {"FailSettlement", false, "InstructionId CounterPartyId"},
+	"clear":         {"Clear", false, ""},
+	"update-config": {"UpdateConfig", false, "Config json"},
+	"migrate":       {"Migrate", false, ""},
+	"repair":        {"Repair", false, ""},
+	"set-limit":     {"SetLimit", false, "Kind CounterPartyId Limit [CreditorId]"},
+	"stats":         {"Stats", true, "[Pool [Top [Currency]]]"},
+	"graph":         {"Graph", true, "[Class]"},
+	"claims":        {"Claims", true, "CounterPartyId [Class]"},
+	"settlements":   {"Settlements", true, "[Status]"},
+	"cycle":         {"Cycle", true, ""},
+	"config":        {"Config", true, ""},
+	"validate":      {"Validate", true, ""},
+	"exposures":     {"Exposures", true, "[CounterPartyId]"},
+	"sequence":      {"Sequence", true, "Liquidity json"},
+	"savings":       {"Savings", true, "[Cycle]"},
+	"list-cycles":   {"Cycles", true, "[MaxLength [Offset [Limit [Pool]]]]"},
+	"trace":         {"Trace", true, "CounterPartyId CounterPartyId [Cycle]"},
+}
+
+func main() {
+	path := flag.String("state", "netting.json", "state file, created by init")
+	caller := flag.String("caller", "admin", "certificate the transactions are signed with")
+	verbose := flag.Bool("v", false, "log what the chaincode does")
+	flag.Usage = usage
+	flag.Parse()
+
+	level := logging.CRITICAL
+	if *verbose {
+		level = logging.DEBUG
+	}
+	logging.SetLevel(level, "")
+
+	if flag.NArg() == 0 || flag.Arg(0) == "help" {
+		usage()
+		return
+	}
+	if flag.Arg(0) == "replay" {
+		if !replay(flag.Args()[1:]) {
+			os.Exit(1)
+		}
+		return
+	}
+	c, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	result, err := run(*path, *caller, c, flag.Args()[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %s\n", flag.Arg(0), strings.TrimSpace(err.Error()))
+		os.Exit(1)
+	}
+	printResult(result)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: nettingctl [flags] command [args...]\n\nflags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "  %-14s %s\n", "replay", "Scenario file...")
+}
+
+// Prints the mismatches of every scenario, false if there are any
+func replay(paths []string) bool {
+	ok := len(paths) > 0
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			ok = false
+			continue
+		}
+		scenario, err := contract.ParseScenario(path, file)
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s", err.Error())
+			ok = false
+			continue
+		}
+		mismatches := scenario.Replay()
+		for _, mismatch := range mismatches {
+			fmt.Println(mismatch)
+		}
+		fmt.Printf("%s: %d mismatches\n", path, len(mismatches))
+		ok = ok && len(mismatches) == 0
+	}
+	return ok
+}
+
+// Time of the transactions
+var clock func() time.Time = time.Now
+
+// The chaincode runs in a MockStub loaded with the state of the file.
+// An invoke writes the file once with what it has changed, or not at all if it fails.
+func run(path string, caller string, c command, args []string) ([]byte, error) {
+	file, err := contract.NewFileStore(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := file.Keys()
+	if len(keys) == 0 && c.function != "init" {
+		return nil, fmt.Errorf("there is no state in %s, run init first", path)
+	}
+	if len(keys) > 0 && c.function == "init" {
+		return nil, fmt.Errorf("%s is initialized already, clear resets it", path)
+	}
+
+	stub := contract.NewMockCallerStub(new(contract.Chaincode), caller)
+	stub.Clock = clock
+	stub.MockTransactionStart("load")
+	for _, key := range keys {
+		value, _ := file.GetState(key)
+		stub.PutState(key, value)
+	}
+	stub.MockTransactionEnd("load")
+
+	txID := strconv.FormatInt(clock().UnixNano(), 10)
+	var result []byte
+	switch {
+	case c.query:
+		return stub.MockQuery(c.function, args)
+	case c.function == "init":
+		result, err = stub.MockInit(txID, c.function, args)
+	default:
+		result, err = stub.MockInvoke(txID, c.function, args)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Save new data
+	writes := map[string][]byte{}
+	deleted := map[string]bool{}
+	for _, key := range keys {
+		if _, ok := stub.State[key]; !ok {
+			deleted[key] = true
+		}
+	}
+	for key, value := range stub.State {
+		if old, _ := file.GetState(key); old == nil || !bytes.Equal(old, value) {
+			writes[key] = value
+		}
+	}
+	if err := file.Commit(writes, deleted); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// JSON is indented, anything else printed as it is
+func printResult(result []byte) {
+	if len(result) == 0 {
+		return
+	}
+	var indented bytes.Buffer
+	if json.Indent(&indented, result, "", "  ") == nil {
+		result = indented.Bytes()
+	}
+	fmt.Println(string(result))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runCommand(t *testing.T, path string, name string, args ...string) string {
+	result, err := run(path, "admin", commands[name], args)
+	if err != nil {
+		fmt.Println("Command", name, args, "failed", err)
+		t.FailNow()
+	}
+	return string(result)
+}
+
+func TestRun_StateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nettingctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "netting.json")
+	clock = func() time.Time { return time.Date(2016, 9, 30, 17, 0, 0, 0, time.UTC) }
+	defer func() { clock = time.Now }()
+
+	if _, err := run(path, "admin", commands["stats"], []string{}); err == nil {
+		fmt.Println("Query without a state file did not fail")
+		t.FailNow()
+	}
+	runCommand(t, path, "init")
+	if _, err := run(path, "admin", commands["init"], []string{}); err == nil {
+		fmt.Println("Second init did not fail")
+		t.FailNow()
+	}
+
+	// Every command reads what the ones before have written
+	runCommand(t, path, "add-party")
+	runCommand(t, path, "add-party")
+	runCommand(t, path, "add-claim", "0", "1", "10")
+	runCommand(t, path, "add-claim", "1", "0", "4")
+	runCommand(t, path, "close-cycle")
+	if cycle := runCommand(t, path, "cycle"); cycle != "{\"open\":2,\"frozen\":1,\"cut_off\":\"2016-09-30T17:00:00Z\",\"netted\":false}" {
+		fmt.Println("Cycle", cycle, "was not cut off at the time of the transaction")
+		t.FailNow()
+	}
+	runCommand(t, path, "run-netting", "EUR")
+	if settlements := runCommand(t, path, "settlements"); !strings.Contains(settlements, "\"amount\":6,\"currency\":\"EUR\",\"value_date\":\"2016-09-30\"") {
+		fmt.Println("Settlements", settlements, "were not for the day of the transaction")
+		t.FailNow()
+	}
+
+	// A failed invoke leaves the file as it was
+	before, _ := ioutil.ReadFile(path)
+	if _, err := run(path, "admin", commands["add-claim"], []string{"0", "1", "ten"}); err == nil {
+		fmt.Println("Claim of ten did not fail")
+		t.FailNow()
+	}
+	if after, _ := ioutil.ReadFile(path); string(after) != string(before) {
+		fmt.Println("Failed invoke changed the file")
+		t.FailNow()
+	}
+
+	// Transactions are signed by the caller, only the one of init is the admin
+	if _, err := run(path, "participant", commands["set-limit"], []string{"gross", "1", "15"}); err == nil {
+		fmt.Println("Limit set by a participant did not fail")
+		t.FailNow()
+	}
+	runCommand(t, path, "set-limit", "gross", "1", "15")
+	if _, err := run(path, "participant", commands["add-party"], []string{}); err != nil {
+		fmt.Println("Counter party added by a participant failed", err)
+		t.FailNow()
+	}
+}
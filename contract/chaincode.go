@@ -0,0 +1,156 @@
+package contract
+
+import (
+	"encoding/hex"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/op/go-logging"
+	"time"
+)
+
+var log = logging.MustGetLogger("chaincode")
+
+func checkCriticalError(e error) {
+	if e != nil {
+		log.Error(e.Error())
+		panic(e)
+	}
+}
+
+// Deferred by Invoke and Query: a critical error, e.g. of a corrupted table,
+// fails the transaction instead of the chaincode
+func recoverCriticalError(err *error) {
+	if r := recover(); r != nil {
+		e, ok := r.(error)
+		if !ok {
+			panic(r)
+		}
+		*err = e
+	}
+}
+
+// NettingChaincode implementation
+type Chaincode struct {
+}
+
+func (t *Chaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	log.Debugf("Init called with function name: %s, with arguments: %s", function, args)
+
+	s, err := newSmartContract(stub)
+	if err != nil {
+		return nil, err
+	}
+	return s.initSmartContract(NewStubStore(stub), args)
+}
+
+func (t *Chaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) (result []byte, err error) {
+	defer recoverCriticalError(&err)
+	log.Debugf("Invoke called with function name: %s, with arguments: %s", function, args)
+
+	f, ok := invokes[function]
+	if ok {
+		s, err := newSmartContract(stub)
+		if err != nil {
+			return nil, err
+		}
+		store := NewStubStore(stub)
+		if err := migrateForInvoke(function, store); err != nil {
+			return nil, err
+		}
+		return f(s, store, args)
+	}
+	return nil, nil
+}
+
+func (t *Chaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) (result []byte, err error) {
+	defer recoverCriticalError(&err)
+	log.Debugf("Query called with function name: %s, with arguments: %s", function, args)
+
+	f, ok := queries[function]
+	if ok {
+		s := smartContract{}
+		store, err := migratedView(function, NewStubStore(stub))
+		if err != nil {
+			return nil, err
+		}
+		return f(s, store, args)
+	}
+	return nil, nil
+}
+
+func newSmartContract(stub shim.ChaincodeStubInterface) (smartContract, error) {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return smartContract{}, err
+	}
+	caller, err := stub.GetCallerCertificate()
+	if err != nil {
+		log.Errorf("stub.GetCallerCertificate() error: %s", err.Error())
+		return smartContract{}, err
+	}
+	binding, err := stub.GetBinding()
+	if err != nil {
+		log.Errorf("stub.GetBinding() error: %s", err.Error())
+		return smartContract{}, err
+	}
+	signed := func(certificate []byte) (bool, error) {
+		return signedBy(stub, certificate)
+	}
+	events := func(name string, payload []byte) error {
+		return setEvent(stub, name, payload)
+	}
+	return smartContract{txTime: txTime, txID: hex.EncodeToString(binding), caller: caller, signedBy: signed,
+		events: events}, nil
+}
+
+// The caller metadata is a signature of the payload and the binding of the transaction,
+// as in the asset management sample of Fabric. Certificates and metadata are sent by the
+// caller, only the signature proves it holds the key of the certificate.
+func signedBy(stub shim.ChaincodeStubInterface, certificate []byte) (bool, error) {
+	if len(certificate) == 0 {
+		return false, nil
+	}
+	signature, err := stub.GetCallerMetadata()
+	if err != nil {
+		log.Errorf("stub.GetCallerMetadata() error: %s", err.Error())
+		return false, err
+	}
+	payload, err := stub.GetPayload()
+	if err != nil {
+		log.Errorf("stub.GetPayload() error: %s", err.Error())
+		return false, err
+	}
+	binding, err := stub.GetBinding()
+	if err != nil {
+		log.Errorf("stub.GetBinding() error: %s", err.Error())
+		return false, err
+	}
+	ok, err := stub.VerifySignature(certificate, signature, append(payload, binding...))
+	if err != nil {
+		log.Errorf("stub.VerifySignature(certificate, signature, message) error: %s", err.Error())
+		return false, err
+	}
+	return ok, nil
+}
+
+// MockStub has no transaction timestamp, zero time is returned then
+// Stubs that are not on the ledger may tell the time themselves
+type txClock interface {
+	TxTime() time.Time
+}
+
+func getTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	if clock, ok := stub.(txClock); ok {
+		if txTime := clock.TxTime(); !txTime.IsZero() {
+			return txTime.UTC(), nil
+		}
+	}
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		log.Errorf("stub.GetTxTimestamp() error: %s", err.Error())
+		return time.Time{}, err
+	}
+	if timestamp == nil {
+		return time.Time{}, nil
+	}
+	return time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC(), nil
+}
\ No newline at end of file
@@ -0,0 +1,337 @@
+package contract
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Class of claims added without one, unless configured otherwise
+const defaultClass string = "trade"
+
+// Classes may only be appended: tables of a class are found by its position
+func checkClassesKept(before []string, after []string) error {
+	for i, class := range before {
+		if i >= len(after) || after[i] != class {
+			message := fmt.Sprintf("classes %v can only be appended to, got %v\n", before, after)
+			log.Errorf(message)
+			return errors.New(message)
+		}
+	}
+	return nil
+}
+
+// Known classes, each once, and offsets between them that make pools:
+// a class in an offset may be netted with itself, and a class that
+// may be netted with two others lets them be netted with each other.
+func (this *config) validateClasses() error {
+	message := ""
+	known := map[string]bool{}
+	for _, class := range this.Classes {
+		if class == "" || known[class] {
+			message = fmt.Sprintf("class %q is empty or repeated\n", class)
+			break
+		}
+		known[class] = true
+	}
+	if len(this.Classes) == 0 {
+		message = "at least one class of claims is needed\n"
+	}
+	for _, offset := range this.Offsets {
+		if message != "" {
+			break
+		}
+		if len(offset) != 2 || !known[offset[0]] || !known[offset[1]] {
+			message = fmt.Sprintf("offset %v is not a pair of known classes\n", offset)
+		}
+	}
+	for _, a := range this.Classes {
+		for _, b := range this.Classes {
+			if message != "" || !this.mayOffset(a, b) {
+				continue
+			}
+			if !this.mayOffset(a, a) {
+				message = fmt.Sprintf("class %s may be offset against %s but not against itself\n", a, b)
+			}
+			for _, c := range this.Classes {
+				if message == "" && this.mayOffset(b, c) && !this.mayOffset(a, c) {
+					message = fmt.Sprintf("classes %s and %s may be offset against %s but not against each other\n", a, c, b)
+				}
+			}
+		}
+	}
+	if message != "" {
+		log.Errorf(message)
+		return errors.New(message)
+	}
+	return nil
+}
+
+// In either order
+func (this *config) mayOffset(a string, b string) bool {
+	for _, offset := range this.Offsets {
+		if len(offset) == 2 && (offset[0] == a && offset[1] == b || offset[0] == b && offset[1] == a) {
+			return true
+		}
+	}
+	return false
+}
+
+// Position of the class, the first one if none is given
+func (this *config) classIndex(class string) (int, error) {
+	if class == "" {
+		return 0, nil
+	}
+	for i, known := range this.Classes {
+		if known == class {
+			return i, nil
+		}
+	}
+	message := fmt.Sprintf("class %s is unknown, known are %v\n", class, this.Classes)
+	log.Errorf(message)
+	return 0, errors.New(message)
+}
+
+// Empty for the first class, which settlement instructions and receipts leave out
+func (this *config) classLabel(class int) string {
+	if class == 0 {
+		return ""
+	}
+	return this.Classes[class]
+}
+
+// Claims of a class in no pool are kept gross, opposite claims are not netted
+func (this *config) isGross(class int) bool {
+	return !this.mayOffset(this.Classes[class], this.Classes[class])
+}
+
+// Positions of the classes netted together. Classes that may not be offset are in none.
+func (this *config) pools() [][]int {
+	pools := [][]int{}
+	pooled := make([]bool, len(this.Classes))
+	for i, a := range this.Classes {
+		if pooled[i] || !this.mayOffset(a, a) {
+			continue
+		}
+		pool := []int{}
+		for j, b := range this.Classes {
+			if this.mayOffset(a, b) {
+				pool = append(pool, j)
+				pooled[j] = true
+			}
+		}
+		pools = append(pools, pool)
+	}
+	return pools
+}
+
+// Claims of the first class are kept under the key itself
+func classKey(key string, class int, cfg *config) string {
+	if class == 0 {
+		return key
+	}
+	return key + "/" + cfg.Classes[class]
+}
+
+// Tables of every class under the key, in the order of the classes. Tables of the other
+// classes are made when first used and know every counter party of the first one.
+// Tables of classes that may not be offset are gross.
+func loadClasses(key string, store Store, cfg *config) ([]Table, error) {
+	first, err := loadClassTable(key, store, cfg.isGross(0))
+	if err != nil {
+		return nil, err
+	}
+	N := counterParties(first)
+
+	tables := []Table{first}
+	for class := 1; class < len(cfg.Classes); class++ {
+		bytes, err := store.GetState(classKey(key, class, cfg))
+		if err != nil {
+			log.Errorf("store.GetState(classKey) error: %s", err.Error())
+			return nil, err
+		}
+		table := newClassTable(N, cfg.isGross(class))
+		if len(bytes) > 0 {
+			if table, err = loadClassTable(classKey(key, class, cfg), store, cfg.isGross(class)); err != nil {
+				return nil, err
+			}
+			for counterParties(table) < N {
+				table.AddCounterParty()
+			}
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+func saveClasses(tables []Table, key string, store Store, cfg *config) error {
+	for class, table := range tables {
+		if err := saveTable(table, classKey(key, class, cfg), store); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Frozen and open claims of every class
+func loadAllClasses(store Store, cfg *config) ([]Table, error) {
+	tables, err := loadClasses(storeKey, store, cfg)
+	if err != nil {
+		return nil, err
+	}
+	openTables, err := loadClasses(openCycleKey, store, cfg)
+	if err != nil {
+		return nil, err
+	}
+	for class := range tables {
+		mergeClaims(tables[class], openTables[class])
+	}
+	return tables, nil
+}
+
+// Every claim in one table, as if the classes could be offset
+func mergeClasses(tables []Table) Table {
+	result := emptyCopy(tables[0])
+	for _, table := range tables {
+		mergeClaims(result, table)
+	}
+	return result
+}
+
+// The class the query args ask for, the first one by default
+func classOf(args []string, i int, cfg *config) (int, error) {
+	if len(args) > i {
+		return cfg.classIndex(args[i])
+	}
+	return 0, nil
+}
+
+// Frozen and open claims of the class the args ask for at i, the first one by default
+func loadClassView(args []string, i int, store Store, cfg *config) (Table, error) {
+	class, err := classOf(args, i, cfg)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := loadAllClasses(store, cfg)
+	checkCriticalError(err)
+	return tables[class], nil
+}
+
+// Nets every pool of classes on its own, and gives the netted claims back to the classes
+// of the pool. Classes in no pool are left as they are, gross. The report covers the claims of every class.
+func optimizeClasses(tables []Table, cfg *config, algorithm string, participants []int) ([]Table, *nettingReport, error) {
+	if algorithm == "" {
+		algorithm = cfg.Algorithm
+	}
+	if netters[algorithm] == nil {
+		message := fmt.Sprintf("unknown netting algorithm %s\n", algorithm)
+		log.Errorf(message)
+		return nil, nil, errors.New(message)
+	}
+
+	netted := append([]Table{}, tables...)
+	report := &nettingReport{}
+	for _, pool := range cfg.pools() {
+		pooled := emptyCopy(tables[pool[0]])
+		for _, class := range pool {
+			mergeClaims(pooled, tables[class])
+			netted[class] = emptyCopy(tables[class])
+		}
+		result, poolReport, err := optimize(pooled, cfg, algorithm, participants)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, table := range splitPool(result, tables, pool) {
+			netted[pool[i]] = table
+		}
+		report.Steps += poolReport.Steps
+		report.Truncated = report.Truncated || poolReport.Truncated
+		for _, round := range poolReport.Rounds {
+			round.Class = cfg.classLabel(pool[0])
+			report.Rounds = append(report.Rounds, round)
+		}
+	}
+	report.Threshold = cfg.NettingThreshold
+	report.fillClasses(algorithm, tables, netted, cfg, participants)
+
+	return netted, report, nil
+}
+
+// Every netted claim goes back to the classes of the pool that had claims in its direction,
+// in the order of the classes, to each up to what it had. What netting has moved onto
+// other claims goes to the first class of the pool.
+func splitPool(result Table, tables []Table, pool []int) []Table {
+	const epsilon = 1e-9
+
+	split := make([]Table, len(pool))
+	had := make([]map[[2]int]float64, len(pool))
+	for i, class := range pool {
+		split[i] = emptyCopy(tables[class])
+		had[i] = map[[2]int]float64{}
+		for _, c := range getAllClaims(tables[class]) {
+			had[i][[2]int{c.From, c.To}] = c.Value
+		}
+	}
+	for _, c := range getAllClaims(result) {
+		left := c.Value
+		for i := range pool {
+			if share := math.Min(left, had[i][[2]int{c.From, c.To}]); share > 0 {
+				split[i].AddClaim(c.From, c.To, share)
+				left -= share
+			}
+		}
+		if left > epsilon {
+			split[0].AddClaim(c.From, c.To, left)
+		}
+	}
+	return split
+}
+
+// Like fill, with the claims between the participants of every class, nil - everyone
+func (this *nettingReport) fillClasses(algorithm string, before []Table, after []Table, cfg *config, participants []int) {
+	claimsOf := func(tables []Table) []claim {
+		claims := []claim{}
+		for _, table := range tables {
+			group, _ := splitTable(table, participants)
+			claims = append(claims, getAllClaims(group)...)
+		}
+		return claims
+	}
+	this.fillClaims(algorithm, counterParties(before[0]), claimsOf(before), claimsOf(after), cfg)
+	if participants != nil {
+		this.Participants = participants
+	}
+}
+
+// Classes netted with the one the args ask for at i, only that one if it is in no pool
+func poolOf(args []string, i int, cfg *config) ([]int, error) {
+	class, err := classOf(args, i, cfg)
+	if err != nil {
+		return nil, err
+	}
+	pool := []int{class}
+	for _, p := range cfg.pools() {
+		for _, c := range p {
+			if c == class {
+				pool = p
+			}
+		}
+	}
+	return pool, nil
+}
+
+// Frozen and open claims of every class netted with the one the args ask for at i
+func loadPoolView(args []string, i int, store Store, cfg *config) (Table, error) {
+	pool, err := poolOf(args, i, cfg)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := loadAllClasses(store, cfg)
+	checkCriticalError(err)
+
+	result := emptyCopy(tables[pool[0]])
+	for _, c := range pool {
+		mergeClaims(result, tables[c])
+	}
+	return result, nil
+}
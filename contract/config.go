@@ -0,0 +1,399 @@
+package contract
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const configKey string = "Config"
+
+// Caller certificate of the Init transaction, only its holder may change the configuration
+const adminKey string = "Admin"
+
+// Configuration document accepted by Init and UpdateConfig, e.g.
+//   {"algorithm":"cycles","metric":"l1","precision":2,"rounding":"half_even",
+//    "zero_tolerance":0.005,"dust":"sweep","dust_account":0,
+//    "max_counter_parties":100,"max_cycle_length":6,"cycle_budget":100000,
+//    "netting_threshold":1,"round_delta":0.01,"runs_kept":10,
+//    "encoding":"binary","compress":true,"currencies":["EUR","USD"],
+//    "classes":["trade","margin","tax"],"offsets":[["trade","trade"],["margin","margin"]],
+//    "operators":["TUlJQi4uLg=="]}
+// Omitted fields keep their current (or default) values.
+type config struct {
+	// Netting algorithm used by RunNetting unless it is asked for another one
+	Algorithm string `json:"algorithm"`
+	// Netting results submitted by operators must improve it
+	Metric string `json:"metric"`
+	// Decimal places amounts are rounded to, negative - no rounding, the default
+	Precision int `json:"precision"`
+	// How amounts halfway between minor units are rounded
+	Rounding string `json:"rounding"`
+	// Amounts not above it are zero
+	ZeroTolerance float64 `json:"zero_tolerance"`
+	// What happens to claims left within the tolerance: dropped or swept to the dust account
+	Dust string `json:"dust"`
+	// Counter party dust is swept to, its own claims are never dust
+	DustAccount int `json:"dust_account"`
+	// 0 - unlimited
+	MaxCounterParties int `json:"max_counter_parties"`
+	// Longest cycle cancelled by netting, 0 - unlimited
+	MaxCycleLength int `json:"max_cycle_length"`
+	// Claims looked at while searching for cycles, 0 - unlimited.
+	// Netting stops there and reports the result as truncated.
+	CycleBudget int `json:"cycle_budget"`
+	// Claims below it are left out of netting, so no cycle through them is cancelled
+	NettingThreshold float64 `json:"netting_threshold"`
+	// Netting is repeated while a round improves the L1 metric by at least that much, 0 - one round
+	RoundDelta float64 `json:"round_delta"`
+	// Netting runs kept for the Trace query, older ones go with the claims they were made of
+	RunsKept int `json:"runs_kept"`
+	// How tables are stored: json or binary, any of them is read
+	Encoding string `json:"encoding"`
+	// Tables are stored gzipped
+	Compress bool `json:"compress"`
+	// Settlement currencies, the first one is the default. Empty - any.
+	Currencies []string `json:"currencies"`
+	// Classes of claims, the first one is the default. They can only be appended to.
+	Classes []string `json:"classes"`
+	// Pairs of classes whose claims may be netted against each other. Claims of a class
+	// in no pair are never netted, they are settled as they are.
+	Offsets [][]string `json:"offsets"`
+	// Certificates of the callers, besides the admin, who may submit netting results, in base64
+	Operators [][]byte `json:"operators"`
+}
+
+const algorithmCycles string = "cycles"
+
+// Claims looked at while searching for cycles by default, tens of milliseconds of search
+const defaultCycleBudget int = 100000
+
+const defaultRunsKept int = 10
+
+const (
+	roundingHalfEven string = "half_even"
+	roundingHalfAway string = "half_away"
+)
+
+const (
+	dustDrop  string = "drop"
+	dustSweep string = "sweep"
+)
+
+func defaultConfig() *config {
+	return &config{
+		Algorithm:         algorithmCycles,
+		Metric:            metricL1,
+		Precision:         -1,
+		Rounding:          roundingHalfEven,
+		ZeroTolerance:     0.0,
+		Dust:              dustDrop,
+		DustAccount:       0,
+		MaxCounterParties: 0,
+		MaxCycleLength:    0,
+		CycleBudget:       defaultCycleBudget,
+		NettingThreshold:  0.0,
+		RoundDelta:        0.0,
+		RunsKept:          defaultRunsKept,
+		Encoding:          encodingJSON,
+		Compress:          false,
+		Currencies:        []string{},
+		Classes:           []string{defaultClass},
+		Offsets:           [][]string{{defaultClass, defaultClass}},
+		Operators:         [][]byte{},
+	}
+}
+
+// args: Config json
+func (this smartContract) invoke_UpdateConfig(store Store, args []string) ([]byte, error) {
+	message := fmt.Sprintf("invokeUpdateConfig called with args: %s\n", args)
+	log.Debugf(message)
+
+	// Check arguments
+	if len(args) < 1 {
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	if err := this.checkAdmin(store); err != nil {
+		return nil, err
+	}
+
+	// Load existing data
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+
+	// Unmarshal reuses the array of the slice
+	classes := append([]string{}, cfg.Classes...)
+	if err := cfg.update(args[0]); err != nil {
+		return nil, err
+	}
+	if err := checkClassesKept(classes, cfg.Classes); err != nil {
+		return nil, err
+	}
+
+	// Save new data
+	err = saveConfig(cfg, store)
+	checkCriticalError(err)
+
+	return nil, nil
+}
+// args: -
+func (this smartContract) query_Config(store Store, args []string) ([]byte, error) {
+	log.Debugf("queryConfig called with args: %s\n", args)
+
+	// Load existing data
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+
+	bts, err := json.Marshal(cfg)
+	if err != nil {
+		log.Errorf("json.Marshal(cfg) error: %s", err.Error())
+		return nil, err
+	}
+
+	return bts, nil
+}
+
+// Init stores the configuration and makes its caller the admin. Without a caller
+// certificate, e.g. with security disabled, there is no admin on-chain.
+func (this smartContract) initConfig(store Store, args []string) error {
+	cfg := defaultConfig()
+	if len(args) > 0 && args[0] != "" {
+		if err := cfg.update(args[0]); err != nil {
+			return err
+		}
+	}
+	if err := saveConfig(cfg, store); err != nil {
+		return err
+	}
+
+	err := store.PutState(adminKey, this.caller)
+	if err != nil {
+		log.Errorf("store.PutState(adminKey, admin) error: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (this smartContract) checkAdmin(store Store) error {
+	ok, err := this.isAdmin(store)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		message := "only the admin may do this\n"
+		log.Errorf(message)
+		return errors.New(message)
+	}
+	return nil
+}
+
+func (this smartContract) isAdmin(store Store) (bool, error) {
+	admin, err := store.GetState(adminKey)
+	if err != nil {
+		log.Errorf("store.GetState(adminKey) error: %s", err.Error())
+		return false, err
+	}
+	if this.signedBy == nil {
+		return true, nil
+	}
+	return this.signedBy(admin)
+}
+
+// Applies a configuration document on top of this one
+func (this *config) update(document string) error {
+	updated := *this
+	err := json.Unmarshal([]byte(document), &updated)
+	if err != nil {
+		log.Errorf("json.Unmarshal(document, &updated) error: %s", err.Error())
+		return err
+	}
+	if err := updated.validate(); err != nil {
+		return err
+	}
+	*this = updated
+	return nil
+}
+
+func (this *config) validate() error {
+	message := ""
+	switch {
+	case netters[this.Algorithm] == nil:
+		message = fmt.Sprintf("unknown netting algorithm %s\n", this.Algorithm)
+	case metrics[this.Metric] == nil:
+		message = fmt.Sprintf("unknown metric %s\n", this.Metric)
+	case this.Precision > 15:
+		message = fmt.Sprintf("precision of %d decimal places is more than float64 keeps\n", this.Precision)
+	case this.Rounding != roundingHalfEven && this.Rounding != roundingHalfAway:
+		message = fmt.Sprintf("unknown rounding %s\n", this.Rounding)
+	case this.ZeroTolerance < 0.0 || math.IsNaN(this.ZeroTolerance):
+		message = fmt.Sprintf("zero tolerance must not be negative, got %v\n", this.ZeroTolerance)
+	case this.Dust != dustDrop && this.Dust != dustSweep:
+		message = fmt.Sprintf("unknown dust policy %s\n", this.Dust)
+	case this.DustAccount < 0:
+		message = fmt.Sprintf("dust account must not be negative, got %d\n", this.DustAccount)
+	case this.MaxCounterParties < 0:
+		message = fmt.Sprintf("max counter parties must not be negative, got %d\n", this.MaxCounterParties)
+	case this.MaxCycleLength < 0 || this.MaxCycleLength == 1:
+		message = fmt.Sprintf("max cycle length must be 0 or at least 2, got %d\n", this.MaxCycleLength)
+	case this.Encoding != encodingJSON && this.Encoding != encodingBinary:
+		message = fmt.Sprintf("unknown encoding %s\n", this.Encoding)
+	case this.Encoding == encodingBinary && this.Precision < 0:
+		message = "binary encoding needs a precision\n"
+	case this.CycleBudget < 0:
+		message = fmt.Sprintf("cycle budget must not be negative, got %d\n", this.CycleBudget)
+	case !(this.NettingThreshold >= 0.0) || math.IsInf(this.NettingThreshold, 0):
+		message = fmt.Sprintf("netting threshold must be a non-negative amount, got %v\n", this.NettingThreshold)
+	case !(this.RoundDelta >= 0.0) || math.IsInf(this.RoundDelta, 0):
+		message = fmt.Sprintf("round delta must be a non-negative number, got %v\n", this.RoundDelta)
+	case this.RunsKept < 1:
+		message = fmt.Sprintf("at least one netting run must be kept, got %d\n", this.RunsKept)
+	}
+	if message != "" {
+		log.Errorf(message)
+		return errors.New(message)
+	}
+	return this.validateClasses()
+}
+
+// Rounds to the configured number of decimal places, halves as configured. The amount is
+// rounded as the shortest decimal that reads back as it, 2.675 is a half like it was written,
+// though the closest float64 is a little below it.
+func (this *config) round(value float64) float64 {
+	if this.Precision < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return value
+	}
+	if value < 0.0 {
+		return -this.round(-value)
+	}
+	decimal := strconv.FormatFloat(value, 'f', -1, 64)
+	point := strings.IndexByte(decimal, '.')
+	if point < 0 || len(decimal)-point-1 <= this.Precision {
+		return value
+	}
+	kept, rest := decimal[:point+1+this.Precision], decimal[point+1+this.Precision:]
+
+	// Minor units kept, and one more if the rest is above a half or a half rounded up
+	units, _ := new(big.Int).SetString(strings.Replace(kept, ".", "", 1), 10)
+	half := rest[0] == '5' && strings.Trim(rest[1:], "0") == ""
+	switch {
+	case rest[0] > '5' || rest[0] == '5' && !half:
+		units.Add(units, big.NewInt(1))
+	case !half:
+	case this.Rounding == roundingHalfAway || units.Bit(0) == 1:
+		units.Add(units, big.NewInt(1))
+	}
+
+	digits := units.String()
+	if len(digits) <= this.Precision {
+		digits = strings.Repeat("0", this.Precision-len(digits)+1) + digits
+	}
+	rounded, _ := strconv.ParseFloat(digits[:len(digits)-this.Precision]+"."+digits[len(digits)-this.Precision:], 64)
+	return rounded
+}
+
+// Queries are not limited by a transaction, so they never search for cycles without a budget
+func (this *config) queryCycleBudget() int {
+	if this.CycleBudget == 0 || this.CycleBudget > defaultCycleBudget {
+		return defaultCycleBudget
+	}
+	return this.CycleBudget
+}
+
+func (this *config) isZero(value float64) bool {
+	return math.Abs(value) <= this.ZeroTolerance
+}
+
+func (this *config) currency(requested string) (string, error) {
+	if requested == "" {
+		if len(this.Currencies) > 0 {
+			return this.Currencies[0], nil
+		}
+		return defaultCurrency, nil
+	}
+	if len(this.Currencies) == 0 {
+		return requested, nil
+	}
+	for _, allowed := range this.Currencies {
+		if allowed == requested {
+			return requested, nil
+		}
+	}
+	message := fmt.Sprintf("currency %s is not allowed, allowed are %v\n", requested, this.Currencies)
+	log.Errorf(message)
+	return "", errors.New(message)
+}
+
+// Rounds every claim and drops the ones that are zero. Dust, claims within the tolerance,
+// is dropped too or swept: owed to the dust account instead, which owes it on.
+func normalizeTable(this Table, cfg *config) Table {
+	sweep := cfg.Dust == dustSweep
+	if sweep && !hasCounterParty(this, cfg.DustAccount) {
+		log.Warningf("dust account %d is not a counter party, dust is dropped\n", cfg.DustAccount)
+		sweep = false
+	}
+	isDustAccount := func(c claim) bool {
+		return sweep && (c.From == cfg.DustAccount || c.To == cfg.DustAccount)
+	}
+
+	result := emptyCopy(this)
+	dust := []claim{}
+	for _, c := range getAllClaims(this) {
+		value := cfg.round(c.Value)
+		switch {
+		case value == 0.0:
+		case !cfg.isZero(value) || isDustAccount(c):
+			result.AddClaim(c.From, c.To, value)
+		case sweep:
+			dust = append(dust, claim{c.From, c.To, value})
+		}
+	}
+	if len(dust) == 0 {
+		return result
+	}
+
+	for _, c := range dust {
+		result.AddClaim(c.From, cfg.DustAccount, c.Value)
+		result.AddClaim(cfg.DustAccount, c.To, c.Value)
+	}
+	// Sums are rounded again, only claims of the dust account have changed
+	return normalizeTable(result, cfg)
+}
+
+func saveConfig(cfg *config, store Store) error {
+	bytes, err := json.Marshal(cfg)
+	if err != nil {
+		log.Errorf("json.Marshal(cfg) error: %s", err.Error())
+		return err
+	}
+	err = store.PutState(configKey, bytes)
+	if err != nil {
+		log.Errorf("store.PutState(configKey, bytes) error: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// Ledgers initialised before the configuration existed get the defaults
+func loadConfig(store Store) (*config, error) {
+	bytes, err := store.GetState(configKey)
+	if err != nil {
+		log.Errorf("store.GetState(configKey) error: %s", err.Error())
+		return nil, err
+	}
+	cfg := defaultConfig()
+	if len(bytes) == 0 {
+		return cfg, nil
+	}
+	err = json.Unmarshal(bytes, cfg)
+	if err != nil {
+		log.Errorf("json.Unmarshal(bytes, cfg) error: %s", err.Error())
+		return nil, err
+	}
+	return cfg, nil
+}
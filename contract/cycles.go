@@ -0,0 +1,157 @@
+package contract
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Claims of the open cycle are kept apart from the NettingTable until the cycle is closed
+const openCycleKey string = "OpenCycle"
+
+const cycleKey string = "Cycle"
+
+// New claims go into the open cycle. CloseCycle freezes it at the cut-off:
+// its claims are moved into the NettingTable and the next cycle is opened.
+// RunNetting nets the NettingTable once per frozen cycle.
+type cycleState struct {
+	Open   int    `json:"open"`
+	Frozen int    `json:"frozen"`
+	CutOff string `json:"cut_off"`
+	Netted bool   `json:"netted"`
+}
+
+// args: -
+func (this smartContract) invoke_CloseCycle(store Store, args []string) ([]byte, error) {
+	log.Debugf("invokeCloseCycle called with args: %s\n", args)
+
+	// Load existing data
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+	cycle, err := loadCycle(store)
+	checkCriticalError(err)
+	tables, err := loadClasses(storeKey, store, cfg)
+	checkCriticalError(err)
+	openTables, err := loadClasses(openCycleKey, store, cfg)
+	checkCriticalError(err)
+
+	// Freeze, every class on its own
+	for class := range tables {
+		mergeClaims(tables[class], openTables[class])
+		tables[class] = normalizeTable(tables[class], cfg)
+		openTables[class] = emptyCopy(tables[class])
+	}
+	cycle.Frozen = cycle.Open
+	// Cut-off is the time of the transaction, so every peer agrees on it
+	cycle.CutOff = ""
+	if !this.txTime.IsZero() {
+		cycle.CutOff = this.txTime.Format(time.RFC3339Nano)
+	}
+	cycle.Netted = false
+
+	// Open the next one, counter parties stay
+	cycle.Open++
+
+	// Save new data
+	err = saveClasses(tables, storeKey, store, cfg)
+	checkCriticalError(err)
+	err = saveClasses(openTables, openCycleKey, store, cfg)
+	checkCriticalError(err)
+	err = saveCycle(cycle, store)
+	checkCriticalError(err)
+
+	if err := this.emitEvent(eventCycleClosed, cycle); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+// args: -
+func (this smartContract) query_Cycle(store Store, args []string) ([]byte, error) {
+	log.Debugf("queryCycle called with args: %s\n", args)
+
+	bytes, err := store.GetState(cycleKey)
+	if err != nil {
+		log.Errorf("store.GetState(cycleKey) error: %s", err.Error())
+		return nil, err
+	}
+
+	return bytes, nil
+}
+
+// RunNetting is allowed once per frozen cycle
+func checkCycleFrozen(cycle *cycleState) error {
+	if cycle.Frozen == 0 {
+		message := "no netting cycle was closed yet\n"
+		log.Errorf(message)
+		return errors.New(message)
+	}
+	if cycle.Netted {
+		message := fmt.Sprintf("netting cycle %d is already netted, cycle %d is open\n", cycle.Frozen, cycle.Open)
+		log.Errorf(message)
+		return errors.New(message)
+	}
+	return nil
+}
+
+// All outstanding claims: frozen and netted ones together with the open cycle
+func loadAll(store Store) (Table, error) {
+	nettingTable, err := load(store)
+	if err != nil {
+		return nil, err
+	}
+	openTable, err := loadTable(openCycleKey, store)
+	if err != nil {
+		return nil, err
+	}
+	mergeClaims(nettingTable, openTable)
+	return nettingTable, nil
+}
+
+func mergeClaims(this Table, other Table) {
+	for _, c := range getAllClaims(other) {
+		this.AddClaim(c.From, c.To, c.Value)
+	}
+}
+
+// Same counter parties and kind of table, no claims
+func emptyCopy(this Table) Table {
+	if gross, ok := this.(*GrossTable); ok {
+		return NewGrossTable(gross.n)
+	}
+	_, dense := this.(*DenseTable)
+	return newTable(counterParties(this), dense)
+}
+
+func saveCycle(cycle *cycleState, store Store) error {
+	bytes, err := json.Marshal(cycle)
+	if err != nil {
+		log.Errorf("json.Marshal(cycle) error: %s", err.Error())
+		return err
+	}
+	err = store.PutState(cycleKey, bytes)
+	if err != nil {
+		log.Errorf("store.PutState(cycleKey, bytes) error: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+func loadCycle(store Store) (*cycleState, error) {
+	bytes, err := store.GetState(cycleKey)
+	if err != nil {
+		log.Errorf("store.GetState(cycleKey) error: %s", err.Error())
+		return nil, err
+	}
+	cycle := cycleState{Open: 1}
+	if len(bytes) == 0 {
+		return &cycle, nil
+	}
+	err = json.Unmarshal(bytes, &cycle)
+	if err != nil {
+		log.Errorf("json.Unmarshal(bytes, &cycle) error: %s", err.Error())
+		return nil, err
+	}
+	return &cycle, nil
+}
@@ -0,0 +1,155 @@
+package contract
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+)
+
+// How tables are stored, either way they are read
+const (
+	encodingJSON   string = "json"
+	encodingBinary string = "binary"
+)
+
+// Binary tables start with it, JSON ones with '{' and compressed ones with gzipMagic
+var binaryMagic []byte = []byte("NTB")
+
+var gzipMagic []byte = []byte{0x1f, 0x8b}
+
+// More than that in a binary table means it is broken
+const maxBinaryCounterParties uint64 = 1 << 24
+
+// Stores the table as configured
+func encodeTable(this Table, cfg *config) ([]byte, error) {
+	var encoded []byte
+	var err error
+	if cfg.Encoding == encodingBinary {
+		encoded, err = binaryTable(this, cfg)
+	} else {
+		encoded, err = wrapTable(this)
+	}
+	if err != nil || !cfg.Compress {
+		return encoded, err
+	}
+
+	// Without a name and a time in the header, the same input gives the same output.
+	// Both encodings list counter parties and claims sorted, so every peer writes the same bytes.
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(encoded); err != nil {
+		log.Errorf("gzip.Write(encoded) error: %s", err.Error())
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		log.Errorf("gzip.Close() error: %s", err.Error())
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// Tells the encoding by the first bytes, whatever the configuration says
+func decodeTable(encoded []byte) (*tableBytes, error) {
+	if bytes.HasPrefix(encoded, gzipMagic) {
+		reader, err := gzip.NewReader(bytes.NewReader(encoded))
+		if err != nil {
+			log.Errorf("gzip.NewReader(encoded) error: %s", err.Error())
+			return nil, err
+		}
+		encoded, err = ioutil.ReadAll(reader)
+		if err != nil {
+			log.Errorf("gzip.Read(encoded) error: %s", err.Error())
+			return nil, err
+		}
+	}
+	if bytes.HasPrefix(encoded, binaryMagic) {
+		return unbinaryTable(encoded[len(binaryMagic):])
+	}
+	return unwrapTable(encoded)
+}
+
+// magic, then unsigned varints: schema version, precision, counter parties, claims,
+// and for every claim from, to and the amount in units of the precision.
+// Counter parties are 0..N-1 in a valid table, so they are not listed.
+func binaryTable(this Table, cfg *config) ([]byte, error) {
+	precision := cfg.Precision
+	if precision < 0 {
+		message := "binary encoding needs amounts rounded to a precision\n"
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	scale := math.Pow(10, float64(precision))
+	claims := getAllClaims(this)
+
+	result := append([]byte{}, binaryMagic...)
+	buffer := make([]byte, binary.MaxVarintLen64)
+	put := func(value uint64) {
+		n := binary.PutUvarint(buffer, value)
+		result = append(result, buffer[:n]...)
+	}
+	put(uint64(schemaVersion))
+	put(uint64(precision))
+	put(uint64(counterParties(this)))
+	put(uint64(len(claims)))
+	for _, c := range claims {
+		// Rounded as configured, so the product is a whole number but for the float error
+		units := math.Floor(cfg.round(c.Value)*scale + 0.5)
+		if units >= math.MaxInt64 {
+			message := fmt.Sprintf("claim %d -> %d of %v is too large for precision %d\n", c.From, c.To, c.Value, precision)
+			log.Errorf(message)
+			return nil, errors.New(message)
+		}
+		put(uint64(c.From))
+		put(uint64(c.To))
+		put(uint64(units))
+	}
+	return result, nil
+}
+
+func unbinaryTable(encoded []byte) (*tableBytes, error) {
+	reader := bytes.NewReader(encoded)
+	broken := false
+	get := func() uint64 {
+		value, err := binary.ReadUvarint(reader)
+		// Short input, reported below
+		broken = broken || err != nil
+		return value
+	}
+
+	version, precision, N, M := get(), get(), get(), get()
+	if broken {
+		message := "binary table header is broken\n"
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	if version > uint64(schemaVersion) {
+		message := fmt.Sprintf("table version %d is newer than %d of this chaincode\n", version, schemaVersion)
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	// A claim takes at least 3 bytes, counter parties are not listed but allocated
+	if precision > 15 || M > uint64(len(encoded)) || N > maxBinaryCounterParties {
+		message := "binary table header is broken\n"
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	scale := math.Pow(10, float64(precision))
+
+	result := &tableBytes{Nodes: make([]int, N), Edges: make([]claim, M)}
+	for i := range result.Nodes {
+		result.Nodes[i] = i
+	}
+	for i := range result.Edges {
+		result.Edges[i] = claim{From: int(get()), To: int(get()), Value: float64(get()) / scale}
+	}
+	if broken || reader.Len() != 0 {
+		message := "binary table is broken\n"
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	return result, nil
+}
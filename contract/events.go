@@ -0,0 +1,69 @@
+package contract
+
+import (
+	"encoding/json"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/VladimirStarostenkov/netting"
+)
+
+// Chaincode event names.
+// Fabric keeps a single event per transaction, so every invoke emits at most one.
+const (
+	eventClaimAdded             string = "ClaimAdded"
+	eventClaimCancelled         string = "ClaimCancelled"
+	eventCounterPartyRegistered string = "CounterPartyRegistered"
+	eventCycleClosed            string = "CycleClosed"
+	eventNettingCompleted       string = "NettingCompleted"
+	eventSettlementConfirmed    string = "SettlementConfirmed"
+	eventSettlementFailed       string = "SettlementFailed"
+)
+
+// ClaimAdded and ClaimCancelled payload:
+//   {"f":1,"t":2,"v":3.14}
+// "v" is the amount added to (or cancelled from) the claim of "f" on "t".
+type claimEvent claim
+
+// CounterPartyRegistered payload:
+//   {"counter_party_id":3}
+type counterPartyEvent struct {
+	CounterPartyId int `json:"counter_party_id"`
+}
+
+// CycleClosed payload, as returned by the Cycle query:
+//   {"open":3,"frozen":2,"cut_off":"2016-09-30T17:00:00Z","netted":false}
+
+// NettingCompleted payload, "before" and "after" have the format of the Stats query,
+// "report" is the result of RunNetting:
+//   {"before":{...},"after":{...},"report":{"algorithm":"cycles",...}}
+type nettingEvent struct {
+	Before netting.NettingTableStats `json:"before"`
+	After  netting.NettingTableStats `json:"after"`
+	Report nettingReport             `json:"report"`
+}
+
+// SettlementConfirmed and SettlementFailed payload is the instruction,
+// as returned by the Settlements query.
+
+// setEvent is swapped in tests, MockStub does not keep events
+var setEvent = func(stub shim.ChaincodeStubInterface, name string, payload []byte) error {
+	return stub.SetEvent(name, payload)
+}
+
+func (this smartContract) emitEvent(name string, payload interface{}) error {
+	if this.events == nil {
+		return nil
+	}
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		log.Errorf("json.Marshal(payload) error: %s", err.Error())
+		return err
+	}
+	err = this.events(name, bytes)
+	if err != nil {
+		log.Errorf("SetEvent(%s) error: %s", name, err.Error())
+		return err
+	}
+	log.Debugf("Event %s : %s\n", name, bytes)
+
+	return nil
+}
\ No newline at end of file
@@ -0,0 +1,102 @@
+package contract
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Keeps the state in a local JSON file, every change or commit is written through.
+// Not safe for concurrent use by several processes.
+type FileStore struct {
+	MemoryStore
+	path string
+}
+
+// The file is created on the first change if it does not exist
+func NewFileStore(path string) (*FileStore, error) {
+	this := &FileStore{MemoryStore: *NewMemoryStore(), path: path}
+
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return this, nil
+	}
+	if err != nil {
+		log.Errorf("ioutil.ReadFile(path) error: %s", err.Error())
+		return nil, err
+	}
+	err = json.Unmarshal(bytes, &this.state)
+	if err != nil {
+		log.Errorf("json.Unmarshal(bytes, &this.state) error: %s", err.Error())
+		return nil, err
+	}
+	if this.state == nil {
+		this.state = make(map[string][]byte)
+	}
+
+	return this, nil
+}
+
+func (this *FileStore) PutState(key string, value []byte) error {
+	this.MemoryStore.PutState(key, value)
+	return this.flush()
+}
+
+func (this *FileStore) DelState(key string) error {
+	this.MemoryStore.DelState(key)
+	return this.flush()
+}
+
+// The file is written once, with all the changes or, if that fails, none of them
+func (this *FileStore) Commit(writes map[string][]byte, deleted map[string]bool) error {
+	old := map[string][]byte{}
+	for key := range writes {
+		old[key] = this.state[key]
+	}
+	for key := range deleted {
+		old[key] = this.state[key]
+	}
+	commitEach(&this.MemoryStore, writes, deleted)
+	if err := this.flush(); err != nil {
+		for key, value := range old {
+			if value == nil {
+				delete(this.state, key)
+			} else {
+				this.state[key] = value
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// A crash leaves either the old or the new file, never a half written one
+func (this *FileStore) flush() error {
+	bytes, err := json.MarshalIndent(this.state, "", "\t")
+	if err != nil {
+		log.Errorf("json.MarshalIndent(this.state) error: %s", err.Error())
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(this.path), filepath.Base(this.path))
+	if err != nil {
+		log.Errorf("ioutil.TempFile() error: %s", err.Error())
+		return err
+	}
+	_, err = temp.Write(bytes)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Errorf("temp.Write(bytes) error: %s", err.Error())
+		os.Remove(temp.Name())
+		return err
+	}
+	err = os.Rename(temp.Name(), this.path)
+	if err != nil {
+		log.Errorf("os.Rename(temp.Name(), this.path) error: %s", err.Error())
+		os.Remove(temp.Name())
+		return err
+	}
+	return nil
+}
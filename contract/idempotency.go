@@ -0,0 +1,119 @@
+package contract
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Receipts of claims submitted with an idempotency key are kept under this prefix
+const claimKeyPrefix string = "ClaimKey."
+
+// Number of times the state was cleared. Receipts cannot be listed to be deleted,
+// so every generation keeps them under its own prefix and keys can be used again.
+const generationKey string = "Generation"
+
+// Generation 0, before there were generations, has the bare prefix
+func receiptKey(key string, generation int) string {
+	if generation == 0 {
+		return claimKeyPrefix + key
+	}
+	return "ClaimKey" + strconv.Itoa(generation) + "." + key
+}
+
+// 0 if the state was never cleared
+func loadGeneration(store Store) (int, error) {
+	bytes, err := store.GetState(generationKey)
+	if err != nil {
+		log.Errorf("store.GetState(generationKey) error: %s", err.Error())
+		return 0, err
+	}
+	if len(bytes) == 0 {
+		return 0, nil
+	}
+	generation, err := strconv.Atoi(string(bytes))
+	if err != nil {
+		log.Errorf("strconv.Atoi(generation) error: %s", err.Error())
+		return 0, err
+	}
+	return generation, nil
+}
+
+func saveGeneration(generation int, store Store) error {
+	err := store.PutState(generationKey, []byte(strconv.Itoa(generation)))
+	if err != nil {
+		log.Errorf("store.PutState(generationKey, generation) error: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// Result of AddClaim. A retried claim gets the receipt of the first submission.
+type claimReceipt struct {
+	claim
+	// Empty for the first class
+	Class   string `json:"class,omitempty"`
+	Cycle   int    `json:"cycle"`
+	Applied bool   `json:"applied"`
+	Key     string `json:"key,omitempty"`
+}
+
+// nil if the key was not used yet
+func loadReceipt(key string, store Store) (*claimReceipt, []byte, error) {
+	generation, err := loadGeneration(store)
+	if err != nil {
+		return nil, nil, err
+	}
+	bytes, err := store.GetState(receiptKey(key, generation))
+	if err != nil {
+		log.Errorf("store.GetState(receiptKey) error: %s", err.Error())
+		return nil, nil, err
+	}
+	if len(bytes) == 0 {
+		return nil, nil, nil
+	}
+
+	var receipt claimReceipt
+	err = json.Unmarshal(bytes, &receipt)
+	if err != nil {
+		log.Errorf("json.Unmarshal(bytes, &receipt) error: %s", err.Error())
+		return nil, nil, err
+	}
+
+	return &receipt, bytes, nil
+}
+
+func saveReceipt(receipt *claimReceipt, store Store) ([]byte, error) {
+	bytes, err := json.Marshal(receipt)
+	if err != nil {
+		log.Errorf("json.Marshal(receipt) error: %s", err.Error())
+		return nil, err
+	}
+	if receipt.Key == "" {
+		return bytes, nil
+	}
+
+	generation, err := loadGeneration(store)
+	if err != nil {
+		return nil, err
+	}
+	err = store.PutState(receiptKey(receipt.Key, generation), bytes)
+	if err != nil {
+		log.Errorf("store.PutState(receiptKey, bytes) error: %s", err.Error())
+		return nil, err
+	}
+
+	return bytes, nil
+}
+
+// A key may only be retried with the very same claim
+func checkRetry(receipt *claimReceipt, c claim, class string) error {
+	if receipt.claim != c || receipt.Class != class {
+		message := fmt.Sprintf("idempotency key %s was used for claim %d -> %d of %v\n",
+			receipt.Key, receipt.From, receipt.To, receipt.Value)
+		log.Errorf(message)
+		return errors.New(message)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package contract
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gonum/graph/simple"
+	"math"
+	"strconv"
+)
+
+// Cycles listed by default
+const defaultCyclesLimit int = 100
+
+// A cycle of claims: every counter party claims from the next one, the last from the first
+type claimCycle struct {
+	CounterParties []int   `json:"counter_parties"`
+	Edges          []claim `json:"edges"`
+	// What cancelling the cycle takes off every claim of it
+	MinWeight float64 `json:"min_weight"`
+	// Gross obligations eliminated by cancelling it alone
+	Saving float64 `json:"saving"`
+}
+
+// Returned by the Cycles query
+type cyclesPage struct {
+	Cycles []claimCycle `json:"cycles"`
+	Offset int          `json:"offset"`
+	// Cycles found up to the end of the page
+	Total int `json:"total"`
+	// There are more cycles after the page, or may be if the cycle budget ran out
+	Truncated bool `json:"truncated"`
+}
+
+// Elementary cycles of the claims as they are now, so that it is seen which claims netting
+// can reduce. They are found by the search RunNetting starts with, but RunNetting cancels
+// every cycle as soon as it finds it, which changes the claims it goes on with: it may not
+// reach all of these cycles, nor in this order. Pool is as in the Stats query.
+// The search stops at the end of the page, and within the cycle budget for queries.
+// args: [MaxLength int (0 - any), [Offset int, [Limit int (at least 1), [Pool string]]]]
+func (this smartContract) query_Cycles(store Store, args []string) ([]byte, error) {
+	message := fmt.Sprintf("queryCycles called with args: %s\n", args)
+	log.Debugf(message)
+
+	// Check arguments
+	numbers := []int{0, 0, defaultCyclesLimit}
+	for i := range numbers {
+		if len(args) <= i || args[i] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(args[i])
+		if err != nil || n < 0 {
+			log.Errorf(message)
+			return nil, errors.New(message)
+		}
+		numbers[i] = n
+	}
+	maxLength, offset, limit := numbers[0], numbers[1], numbers[2]
+	if limit == 0 {
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+
+	// Load existing data
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+	nettingTable, err := loadPoolView(args, 3, store, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := toGraph(nettingTable)
+	page := cyclesPage{Cycles: []claimCycle{}, Offset: offset}
+	complete := findCycles(nettingTable, maxLength, cfg.queryCycleBudget(), func(path []int) bool {
+		if page.Total >= offset && len(page.Cycles) >= limit {
+			// One more than the page
+			return false
+		}
+		page.Total++
+		if page.Total <= offset {
+			return true
+		}
+		cycle := claimCycle{CounterParties: append([]int{}, path...), MinWeight: math.MaxFloat64}
+		for i, from := range path {
+			to := path[(i+1)%len(path)]
+			weight := graph.Edge(simple.Node(from), simple.Node(to)).Weight()
+			cycle.Edges = append(cycle.Edges, claim{From: from, To: to, Value: weight})
+			cycle.MinWeight = math.Min(cycle.MinWeight, weight)
+		}
+		cycle.Saving = cfg.round(cycle.MinWeight * float64(len(path)))
+		page.Cycles = append(page.Cycles, cycle)
+		return true
+	})
+	page.Truncated = !complete
+
+	bts, err := json.Marshal(page)
+	if err != nil {
+		log.Errorf("json.Marshal(page) error: %s", err.Error())
+		return nil, err
+	}
+
+	return bts, nil
+}
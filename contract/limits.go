@@ -0,0 +1,279 @@
+package contract
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const limitsKey string = "Limits"
+
+// Kinds of limits
+const (
+	limitGross string = "gross"
+	limitNet   string = "net"
+	limitPair  string = "pair"
+)
+
+// Credit limits on what counter parties owe, by debtor ID. No limit - unlimited.
+type exposureLimits struct {
+	// Sum of the claims on a counter party
+	Gross map[int]float64 `json:"gross"`
+	// What a counter party owes net of what it is owed, -CalcH
+	Net map[int]float64 `json:"net"`
+	// The claim of a creditor on a counter party, by debtor and creditor
+	Pair map[int]map[int]float64 `json:"pair"`
+}
+
+// Returned by the Exposures query
+type exposure struct {
+	CounterParty int     `json:"counter_party"`
+	Gross        float64 `json:"gross"`
+	GrossLimit   float64 `json:"gross_limit"`
+	Net          float64 `json:"net"`
+	NetLimit     float64 `json:"net_limit"`
+	// Share of the most used limit, 0 without limits
+	Utilisation float64        `json:"utilisation"`
+	Pairs       []pairExposure `json:"pairs"`
+}
+
+type pairExposure struct {
+	Creditor int     `json:"creditor"`
+	Exposure float64 `json:"exposure"`
+	Limit    float64 `json:"limit"`
+}
+
+func newExposureLimits() *exposureLimits {
+	return &exposureLimits{Gross: map[int]float64{}, Net: map[int]float64{}, Pair: map[int]map[int]float64{}}
+}
+
+// Limit 0 removes the limit.
+// args: Kind string (gross, net or pair), CounterPartyId int, Limit float, [CreditorId int for pair]
+func (this smartContract) invoke_SetLimit(store Store, args []string) ([]byte, error) {
+	message := fmt.Sprintf("invokeSetLimit called with args: %s\n", args)
+	log.Debugf(message)
+
+	// Check arguments
+	if len(args) < 3 || args[0] == limitPair && len(args) < 4 {
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	if err := this.checkAdmin(store); err != nil {
+		return nil, err
+	}
+	kind := args[0]
+	debtor, err := strconv.Atoi(args[1])
+	if err != nil {
+		log.Errorf("strconv.Atoi(args[1]) error: %s", err.Error())
+		return nil, err
+	}
+	limit, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		log.Errorf("strconv.ParseFloat(args[2]) error: %s", err.Error())
+		return nil, err
+	}
+	if limit < 0.0 || math.IsNaN(limit) || math.IsInf(limit, 0) {
+		message := fmt.Sprintf("limit must be a non-negative amount, got %v\n", limit)
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+
+	// Load existing data
+	nettingTable, err := load(store)
+	checkCriticalError(err)
+	limits, err := loadLimits(store)
+	checkCriticalError(err)
+
+	if !hasCounterParty(nettingTable, debtor) {
+		message := fmt.Sprintf("counter party %d is unknown\n", debtor)
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	switch kind {
+	case limitGross:
+		setLimit(limits.Gross, debtor, limit)
+	case limitNet:
+		setLimit(limits.Net, debtor, limit)
+	case limitPair:
+		creditor, err := strconv.Atoi(args[3])
+		if err != nil {
+			log.Errorf("strconv.Atoi(args[3]) error: %s", err.Error())
+			return nil, err
+		}
+		if !hasCounterParty(nettingTable, creditor) || creditor == debtor {
+			message := fmt.Sprintf("creditor %d is unknown or the counter party itself\n", creditor)
+			log.Errorf(message)
+			return nil, errors.New(message)
+		}
+		if limits.Pair[debtor] == nil {
+			limits.Pair[debtor] = map[int]float64{}
+		}
+		setLimit(limits.Pair[debtor], creditor, limit)
+		if len(limits.Pair[debtor]) == 0 {
+			delete(limits.Pair, debtor)
+		}
+	default:
+		message := fmt.Sprintf("unknown kind of limit %s\n", kind)
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+
+	// Save new data
+	err = saveLimits(limits, store)
+	checkCriticalError(err)
+
+	return nil, nil
+}
+
+func setLimit(limits map[int]float64, id int, limit float64) {
+	if limit == 0.0 {
+		delete(limits, id)
+	} else {
+		limits[id] = limit
+	}
+}
+
+// args: [CounterPartyId int]
+func (this smartContract) query_Exposures(store Store, args []string) ([]byte, error) {
+	log.Debugf("queryExposures called with args: %s\n", args)
+
+	// Load existing data
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+	tables, err := loadAllClasses(store, cfg)
+	checkCriticalError(err)
+	limits, err := loadLimits(store)
+	checkCriticalError(err)
+
+	exposures := limits.exposures(tables, cfg)
+	if len(args) > 0 {
+		id, err := strconv.Atoi(args[0])
+		if err != nil || !hasCounterParty(tables[0], id) {
+			message := fmt.Sprintf("counter party %s is unknown\n", args[0])
+			log.Errorf(message)
+			return nil, errors.New(message)
+		}
+		exposures = exposures[id : id+1]
+	}
+
+	bts, err := json.Marshal(exposures)
+	if err != nil {
+		log.Errorf("json.Marshal(exposures) error: %s", err.Error())
+		return nil, err
+	}
+
+	return bts, nil
+}
+
+// Of every counter party, in the order of IDs. Claims of different classes add up.
+func (this *exposureLimits) exposures(tables []Table, cfg *config) []exposure {
+	positions := make([]float64, counterParties(tables[0]))
+	exposures := make([]exposure, len(positions))
+	pairs := map[int]map[int]float64{}
+	for id := range exposures {
+		pairs[id] = map[int]float64{}
+	}
+	for _, table := range tables {
+		for id, position := range netPositions(table) {
+			positions[id] += position
+		}
+		for _, c := range getAllClaims(table) {
+			exposures[c.To].Gross += c.Value
+			pairs[c.To][c.From] += c.Value
+		}
+	}
+
+	for id := range exposures {
+		e := &exposures[id]
+		e.CounterParty = id
+		e.Gross = cfg.round(e.Gross)
+		e.GrossLimit = this.Gross[id]
+		e.Net = cfg.round(math.Max(0.0, -positions[id]))
+		e.NetLimit = this.Net[id]
+		e.Pairs = []pairExposure{}
+		e.Utilisation = math.Max(utilisation(e.Gross, e.GrossLimit), utilisation(e.Net, e.NetLimit))
+		for creditor := range positions {
+			limit, ok := this.Pair[id][creditor]
+			if !ok {
+				continue
+			}
+			pair := pairExposure{Creditor: creditor, Exposure: cfg.round(pairs[id][creditor]), Limit: limit}
+			e.Pairs = append(e.Pairs, pair)
+			e.Utilisation = math.Max(e.Utilisation, utilisation(pair.Exposure, pair.Limit))
+		}
+		e.Utilisation = cfg.round(e.Utilisation)
+	}
+	return exposures
+}
+
+func utilisation(exposure float64, limit float64) float64 {
+	if limit == 0.0 {
+		return 0.0
+	}
+	return exposure / limit
+}
+
+// Exposures of the debtor may only grow up to its limits, before and after are all claims
+func (this *exposureLimits) check(before []Table, after []Table, debtor int, cfg *config) error {
+	if len(this.Gross) == 0 && len(this.Net) == 0 && len(this.Pair) == 0 {
+		return nil
+	}
+	was := this.exposures(before, cfg)[debtor]
+	is := this.exposures(after, cfg)[debtor]
+
+	exceeds := func(kind string, was float64, is float64, limit float64) error {
+		if limit == 0.0 || is <= was || is <= limit {
+			return nil
+		}
+		message := fmt.Sprintf("%s exposure to %d of %v would exceed the limit of %v\n", kind, debtor, is, limit)
+		log.Errorf(message)
+		return errors.New(message)
+	}
+	if err := exceeds(limitGross, was.Gross, is.Gross, is.GrossLimit); err != nil {
+		return err
+	}
+	if err := exceeds(limitNet, was.Net, is.Net, is.NetLimit); err != nil {
+		return err
+	}
+	for i, pair := range is.Pairs {
+		if err := exceeds(limitPair, was.Pairs[i].Exposure, pair.Exposure, pair.Limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// No limits when there are none on the ledger
+func loadLimits(store Store) (*exposureLimits, error) {
+	bytes, err := store.GetState(limitsKey)
+	if err != nil {
+		log.Errorf("store.GetState(limitsKey) error: %s", err.Error())
+		return nil, err
+	}
+	limits := newExposureLimits()
+	if len(bytes) == 0 {
+		return limits, nil
+	}
+	err = json.Unmarshal(bytes, limits)
+	if err != nil {
+		log.Errorf("json.Unmarshal(bytes, limits) error: %s", err.Error())
+		return nil, err
+	}
+	return limits, nil
+}
+
+func saveLimits(limits *exposureLimits, store Store) error {
+	bytes, err := json.Marshal(limits)
+	if err != nil {
+		log.Errorf("json.Marshal(limits) error: %s", err.Error())
+		return err
+	}
+	err = store.PutState(limitsKey, bytes)
+	if err != nil {
+		log.Errorf("store.PutState(limitsKey, bytes) error: %s", err.Error())
+		return err
+	}
+	return nil
+}
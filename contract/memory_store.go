@@ -0,0 +1,52 @@
+package contract
+
+import (
+	"sort"
+)
+
+// Keeps the state in memory, for tests and batch jobs
+type MemoryStore struct {
+	state map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{state: make(map[string][]byte)}
+}
+
+func (this *MemoryStore) GetState(key string) ([]byte, error) {
+	return this.state[key], nil
+}
+
+func (this *MemoryStore) PutState(key string, value []byte) error {
+	this.state[key] = value
+	return nil
+}
+
+func (this *MemoryStore) DelState(key string) error {
+	delete(this.state, key)
+	return nil
+}
+
+func (this *MemoryStore) RangeQueryState(startKey string, endKey string) (map[string][]byte, error) {
+	entries := map[string][]byte{}
+	for key, value := range this.state {
+		if key >= startKey && key <= endKey {
+			entries[key] = value
+		}
+	}
+	return entries, nil
+}
+
+func (this *MemoryStore) Commit(writes map[string][]byte, deleted map[string]bool) error {
+	return commitEach(this, writes, deleted)
+}
+
+// Sorted
+func (this *MemoryStore) Keys() []string {
+	keys := make([]string, 0, len(this.state))
+	for key := range this.state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,81 @@
+package contract
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"time"
+)
+
+// MockStub of a caller with a certificate, who signs every transaction it sends.
+// MockStub itself has no caller, and no signature verifies with it.
+// A signature is the SHA-256 of the certificate and the message, so anyone can make one,
+// this is only for scenarios, tests and local tools.
+type MockCallerStub struct {
+	*shim.MockStub
+	cc          shim.Chaincode
+	certificate []byte
+	// Time of the transactions, nil - unknown as with MockStub
+	Clock func() time.Time
+}
+
+func NewMockCallerStub(cc shim.Chaincode, certificate string) *MockCallerStub {
+	return &MockCallerStub{MockStub: shim.NewMockStub("netting", cc), cc: cc, certificate: []byte(certificate)}
+}
+
+// Another caller of the same chaincode and state
+func (this *MockCallerStub) as(certificate string) *MockCallerStub {
+	return &MockCallerStub{MockStub: this.MockStub, cc: this.cc, certificate: []byte(certificate), Clock: this.Clock}
+}
+
+// Read by the chaincode instead of the timestamp, which MockStub does not have
+func (this *MockCallerStub) TxTime() time.Time {
+	if this.Clock == nil {
+		return time.Time{}
+	}
+	return this.Clock()
+}
+
+func mockSignature(certificate []byte, message []byte) []byte {
+	signature := sha256.Sum256(append(append([]byte{}, certificate...), message...))
+	return signature[:]
+}
+
+func (this *MockCallerStub) GetCallerCertificate() ([]byte, error) {
+	return this.certificate, nil
+}
+
+func (this *MockCallerStub) GetCallerMetadata() ([]byte, error) {
+	payload, _ := this.GetPayload()
+	binding, _ := this.GetBinding()
+	return mockSignature(this.certificate, append(payload, binding...)), nil
+}
+
+// The transaction, so that a signature is only good for it
+func (this *MockCallerStub) GetBinding() ([]byte, error) {
+	return []byte(this.TxID), nil
+}
+
+func (this *MockCallerStub) VerifySignature(certificate, signature, message []byte) (bool, error) {
+	return len(certificate) > 0 && bytes.Equal(signature, mockSignature(certificate, message)), nil
+}
+
+// MockStub calls the chaincode with itself, so these call it with the caller instead
+
+func (this *MockCallerStub) MockInit(uuid string, function string, args []string) ([]byte, error) {
+	this.MockTransactionStart(uuid)
+	bytes, err := this.cc.Init(this, function, args)
+	this.MockTransactionEnd(uuid)
+	return bytes, err
+}
+
+func (this *MockCallerStub) MockInvoke(uuid string, function string, args []string) ([]byte, error) {
+	this.MockTransactionStart(uuid)
+	bytes, err := this.cc.Invoke(this, function, args)
+	this.MockTransactionEnd(uuid)
+	return bytes, err
+}
+
+func (this *MockCallerStub) MockQuery(function string, args []string) ([]byte, error) {
+	return this.cc.Query(this, function, args)
+}
@@ -0,0 +1,445 @@
+package contract
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A netting algorithm. It must keep the net position of every counter party.
+type Netter interface {
+	// The input table is not changed
+	Net(this Table, cfg *config) (Table, *nettingReport)
+	// Whether every netted claim is a part of an original one:
+	// between the same counter parties, in the same direction and not larger
+	KeepsClaims() bool
+}
+
+// Algorithms RunNetting can be asked for, by name
+var netters map[string]Netter = map[string]Netter{
+	algorithmCycles:       cycleNetter{},
+	algorithmBilateral:    bilateralNetter{},
+	algorithmMinCostFlow:  minCostFlowNetter{},
+	algorithmPaymentCount: paymentCountNetter{},
+}
+
+const (
+	algorithmBilateral    string = "bilateral"
+	algorithmMinCostFlow  string = "mincostflow"
+	algorithmPaymentCount string = "paymentcount"
+)
+
+// Returned by RunNetting
+type nettingReport struct {
+	Algorithm    string  `json:"algorithm"`
+	ClaimsBefore int     `json:"claims_before"`
+	ClaimsAfter  int     `json:"claims_after"`
+	GrossBefore  float64 `json:"gross_before"`
+	GrossAfter   float64 `json:"gross_after"`
+	// Cycles cancelled, paths augmented or payments matched, depending on the algorithm
+	Steps int `json:"steps"`
+	// Stopped by the cycle budget, so more could be netted
+	Truncated bool `json:"truncated"`
+	// Counter parties whose claims on each other were netted, in the order of IDs
+	Participants []int `json:"participants"`
+	// Claims below it were left as they are
+	Threshold float64 `json:"threshold,omitempty"`
+	// Only when rounds are repeated, see config.RoundDelta
+	Rounds []nettingRound `json:"rounds,omitempty"`
+	// Fingerprint of the certificate of who has submitted the result, see SubmitNettingResult
+	Submitter string `json:"submitter,omitempty"`
+}
+
+// The table after a round of netting
+type nettingRound struct {
+	Round int `json:"round"`
+	// Of the pool netted, empty for the first class
+	Class    string  `json:"class,omitempty"`
+	MetricL1 float64 `json:"metric_l1"`
+	Claims   int     `json:"claims"`
+	Gross    float64 `json:"gross"`
+	Steps    int     `json:"steps"`
+}
+
+// Runs the named algorithm, the configured one if no name is given, on the claims
+// between the participants, nil - everyone. Claims of the others are left as they are,
+// and so are claims below the netting threshold. With a round delta the algorithm
+// is run again on its result as long as the L1 metric improves by at least the delta.
+func optimize(this Table, cfg *config, algorithm string, participants []int) (Table, *nettingReport, error) {
+	if algorithm == "" {
+		algorithm = cfg.Algorithm
+	}
+	netter, ok := netters[algorithm]
+	if !ok {
+		message := fmt.Sprintf("unknown netting algorithm %s\n", algorithm)
+		log.Errorf(message)
+		return nil, nil, errors.New(message)
+	}
+
+	group, others := splitTable(this, participants)
+	group, small := splitSmall(group, cfg.NettingThreshold)
+	mergeClaims(others, small)
+
+	result, report := netter.Net(group, cfg)
+	result = normalizeTable(result, cfg)
+	if cfg.RoundDelta > 0.0 {
+		report.Rounds = []nettingRound{newNettingRound(1, result, report.Steps, cfg)}
+	}
+	for improved := cfg.RoundDelta > 0.0; improved; {
+		next, nextReport := netter.Net(result, cfg)
+		next = normalizeTable(next, cfg)
+		improvement := getStats(result).MetricL1 - getStats(next).MetricL1
+		improved = improvement >= cfg.RoundDelta
+		if !(improvement > 0.0) {
+			// Nothing gained, the round is dropped
+			break
+		}
+		result = next
+		report.Steps += nextReport.Steps
+		report.Truncated = nextReport.Truncated
+		report.Rounds = append(report.Rounds, newNettingRound(len(report.Rounds)+1, result, nextReport.Steps, cfg))
+	}
+
+	report.fill(algorithm, group, result, cfg)
+	report.Threshold = cfg.NettingThreshold
+	if participants != nil {
+		report.Participants = participants
+	}
+	mergeClaims(result, others)
+
+	return result, report, nil
+}
+
+// Claims between the participants and the rest, nil - everyone participates
+func splitTable(this Table, participants []int) (group Table, others Table) {
+	group, others = emptyCopy(this), emptyCopy(this)
+	participating := map[int]bool{}
+	for _, id := range participants {
+		participating[id] = true
+	}
+	for _, c := range getAllClaims(this) {
+		if participants == nil || participating[c.From] && participating[c.To] {
+			group.AddClaim(c.From, c.To, c.Value)
+		} else {
+			others.AddClaim(c.From, c.To, c.Value)
+		}
+	}
+	return
+}
+
+// Claims not below the threshold and the rest
+func splitSmall(this Table, threshold float64) (large Table, small Table) {
+	large, small = emptyCopy(this), emptyCopy(this)
+	for _, c := range getAllClaims(this) {
+		if c.Value < threshold {
+			small.AddClaim(c.From, c.To, c.Value)
+		} else {
+			large.AddClaim(c.From, c.To, c.Value)
+		}
+	}
+	return
+}
+
+func newNettingRound(round int, result Table, steps int, cfg *config) nettingRound {
+	stats := getStats(result)
+	return nettingRound{Round: round, MetricL1: stats.MetricL1, Claims: stats.NumberOfClaims,
+		Gross: cfg.round(grossOf(getAllClaims(result))), Steps: steps}
+}
+
+// Comma separated counter party IDs, at least 2 of them. Empty - everyone, nil is returned.
+func parseParticipants(this Table, arg string) ([]int, error) {
+	if arg == "" {
+		return nil, nil
+	}
+	participants := []int{}
+	seen := map[int]bool{}
+	for _, field := range strings.Split(arg, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			log.Errorf("strconv.Atoi(participant) error: %s", err.Error())
+			return nil, err
+		}
+		if !hasCounterParty(this, id) || seen[id] {
+			message := fmt.Sprintf("participant %d is unknown or repeated\n", id)
+			log.Errorf(message)
+			return nil, errors.New(message)
+		}
+		seen[id] = true
+		participants = append(participants, id)
+	}
+	if len(participants) < 2 {
+		message := "netting needs at least 2 participants\n"
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	sort.Ints(participants)
+	return participants, nil
+}
+
+func (this *nettingReport) fill(algorithm string, before Table, after Table, cfg *config) {
+	this.fillClaims(algorithm, counterParties(before), getAllClaims(before), getAllClaims(after), cfg)
+}
+
+// Everyone of N counter parties participates
+func (this *nettingReport) fillClaims(algorithm string, N int, claimsBefore []claim, claimsAfter []claim, cfg *config) {
+	this.Algorithm = algorithm
+	this.ClaimsBefore = len(claimsBefore)
+	this.ClaimsAfter = len(claimsAfter)
+	this.GrossBefore = cfg.round(grossOf(claimsBefore))
+	this.GrossAfter = cfg.round(grossOf(claimsAfter))
+	this.Participants = make([]int, N)
+	for id := range this.Participants {
+		this.Participants[id] = id
+	}
+}
+
+// Cancels cycles of claims, the original NettingTable.Optimize algorithm
+type cycleNetter struct{}
+
+func (cycleNetter) Net(this Table, cfg *config) (Table, *nettingReport) {
+	result, cancelled, truncated := cancelCycles(this, cfg.MaxCycleLength, cfg.CycleBudget)
+	return result, &nettingReport{Steps: cancelled, Truncated: truncated}
+}
+
+func (cycleNetter) KeepsClaims() bool { return true }
+
+// Opposite claims are already netted by AddClaim, so the table stays as it is.
+// Useful as the baseline the other algorithms are compared to.
+type bilateralNetter struct{}
+
+func (bilateralNetter) Net(this Table, cfg *config) (Table, *nettingReport) {
+	return normalizeTable(this, cfg), &nettingReport{}
+}
+
+func (bilateralNetter) KeepsClaims() bool { return true }
+
+// Finds the smallest claims on the existing edges that keep every net position:
+// a min-cost flow from net creditors to net debtors where every edge costs 1 per unit
+// and is bounded by its current claim. Successive shortest paths, Bellman-Ford.
+type minCostFlowNetter struct{}
+
+func (minCostFlowNetter) Net(this Table, cfg *config) (Table, *nettingReport) {
+	const epsilon = 1e-9
+
+	N := counterParties(this)
+	source, sink := N, N+1
+	network := newFlowNetwork(N + 2)
+
+	claims := getAllClaims(this)
+	arcs := make([]int, len(claims))
+	for i, c := range claims {
+		arcs[i] = network.addArc(c.From, c.To, c.Value, 1.0)
+	}
+	for id, position := range netPositions(this) {
+		if position > epsilon {
+			network.addArc(source, id, position, 0.0)
+		} else if position < -epsilon {
+			network.addArc(id, sink, -position, 0.0)
+		}
+	}
+
+	augmented := 0
+	for {
+		path := network.shortestPath(source, sink, epsilon)
+		if path == nil {
+			break
+		}
+		bottleneck := math.MaxFloat64
+		for _, a := range path {
+			bottleneck = math.Min(bottleneck, network.arcs[a].capacity)
+		}
+		for _, a := range path {
+			network.push(a, bottleneck)
+		}
+		augmented++
+	}
+
+	result := emptyCopy(this)
+	for i, c := range claims {
+		if flow := network.arcs[arcs[i]].flow; flow > epsilon {
+			result.AddClaim(c.From, c.To, flow)
+		}
+	}
+	return result, &nettingReport{Steps: augmented}
+}
+
+func (minCostFlowNetter) KeepsClaims() bool { return true }
+
+// Replaces all claims by as few as possible that keep every net position.
+// Counter parties may end up with claims on ones they never traded with.
+// Equal opposite positions are matched first, the rest greedily largest to largest.
+type paymentCountNetter struct{}
+
+func (paymentCountNetter) Net(this Table, cfg *config) (Table, *nettingReport) {
+	creditors := []position{}
+	debtors := []position{}
+	for id, amount := range netPositions(this) {
+		amount = cfg.round(amount)
+		if cfg.isZero(amount) {
+			continue
+		}
+		if amount > 0.0 {
+			creditors = append(creditors, position{CounterParty: id, Amount: amount})
+		} else {
+			debtors = append(debtors, position{CounterParty: id, Amount: -amount})
+		}
+	}
+
+	result := emptyCopy(this)
+	payments := 0
+	pay := func(creditor *position, debtor *position, amount float64) {
+		result.AddClaim(creditor.CounterParty, debtor.CounterParty, amount)
+		creditor.Amount = cfg.round(creditor.Amount - amount)
+		debtor.Amount = cfg.round(debtor.Amount - amount)
+		payments++
+	}
+
+	// Exact matches settle two parties with one payment
+	sort.Sort(positionsByAmount(creditors))
+	sort.Sort(positionsByAmount(debtors))
+	for i := range debtors {
+		for j := range creditors {
+			if !cfg.isZero(debtors[i].Amount) && cfg.isZero(creditors[j].Amount-debtors[i].Amount) {
+				pay(&creditors[j], &debtors[i], debtors[i].Amount)
+				break
+			}
+		}
+	}
+
+	// Largest creditor from largest debtor
+	for {
+		creditors = nonZeroPositions(creditors, cfg)
+		debtors = nonZeroPositions(debtors, cfg)
+		if len(creditors) == 0 || len(debtors) == 0 {
+			break
+		}
+		sort.Sort(positionsByAmount(creditors))
+		sort.Sort(positionsByAmount(debtors))
+		pay(&creditors[0], &debtors[0], math.Min(creditors[0].Amount, debtors[0].Amount))
+	}
+
+	return result, &nettingReport{Steps: payments}
+}
+
+func (paymentCountNetter) KeepsClaims() bool { return false }
+
+type position struct {
+	CounterParty int
+	Amount       float64
+}
+
+// Largest first, then by counter party so that every peer gets the same order
+type positionsByAmount []position
+
+func (p positionsByAmount) Len() int      { return len(p) }
+func (p positionsByAmount) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p positionsByAmount) Less(i, j int) bool {
+	if p[i].Amount != p[j].Amount {
+		return p[i].Amount > p[j].Amount
+	}
+	return p[i].CounterParty < p[j].CounterParty
+}
+
+func nonZeroPositions(positions []position, cfg *config) []position {
+	result := positions[:0]
+	for _, p := range positions {
+		if !cfg.isZero(p.Amount) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// Claims of each counter party on the others minus their claims on it
+func netPositions(this Table) []float64 {
+	positions := make([]float64, counterParties(this))
+	for _, c := range getAllClaims(this) {
+		positions[c.From] += c.Value
+		positions[c.To] -= c.Value
+	}
+	return positions
+}
+
+func grossOf(claims []claim) (gross float64) {
+	for _, c := range claims {
+		gross += c.Value
+	}
+	return
+}
+
+// Residual network for minCostFlowNetter, every arc is followed by its reverse one
+type flowNetwork struct {
+	arcs []flowArc
+	out  [][]int
+}
+
+type flowArc struct {
+	to       int
+	capacity float64
+	cost     float64
+	flow     float64
+}
+
+func newFlowNetwork(nodes int) *flowNetwork {
+	return &flowNetwork{out: make([][]int, nodes)}
+}
+
+func (this *flowNetwork) addArc(from int, to int, capacity float64, cost float64) int {
+	a := len(this.arcs)
+	this.arcs = append(this.arcs, flowArc{to: to, capacity: capacity, cost: cost})
+	this.arcs = append(this.arcs, flowArc{to: from, capacity: 0.0, cost: -cost})
+	this.out[from] = append(this.out[from], a)
+	this.out[to] = append(this.out[to], a+1)
+	return a
+}
+
+func (this *flowNetwork) push(a int, amount float64) {
+	this.arcs[a].capacity -= amount
+	this.arcs[a].flow += amount
+	this.arcs[a^1].capacity += amount
+	this.arcs[a^1].flow -= amount
+}
+
+// Arcs of the cheapest path with capacity left, nil if there is none.
+// Successive shortest paths never leave negative cycles, so Bellman-Ford terminates.
+func (this *flowNetwork) shortestPath(source int, sink int, epsilon float64) []int {
+	distance := make([]float64, len(this.out))
+	via := make([]int, len(this.out))
+	for i := range distance {
+		distance[i] = math.Inf(1)
+		via[i] = -1
+	}
+	distance[source] = 0.0
+
+	for round := 0; round < len(this.out); round++ {
+		changed := false
+		for from := range this.out {
+			if math.IsInf(distance[from], 1) {
+				continue
+			}
+			for _, a := range this.out[from] {
+				arc := this.arcs[a]
+				if arc.capacity > epsilon && distance[from]+arc.cost < distance[arc.to]-epsilon {
+					distance[arc.to] = distance[from] + arc.cost
+					via[arc.to] = a
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	if via[sink] < 0 {
+		return nil
+	}
+
+	path := []int{}
+	for node := sink; node != source; node = this.arcs[via[node]^1].to {
+		path = append([]int{via[node]}, path...)
+	}
+	return path
+}
@@ -0,0 +1,1401 @@
+package contract
+
+import (
+	"fmt"
+	"testing"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"encoding/json"
+	"github.com/VladimirStarostenkov/netting"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func checkInit(t *testing.T, stub *MockCallerStub, args []string) {
+	_, err := stub.MockInit("1", "init", args)
+	if err != nil {
+		fmt.Println("Init failed", err)
+		t.FailNow()
+	}
+}
+
+func checkState(t *testing.T, stub *MockCallerStub, name string, value string) {
+	bytes := stub.State[name]
+	if bytes == nil {
+		fmt.Println("State", name, "failed to get value")
+		t.FailNow()
+	}
+	if string(bytes) != value {
+		fmt.Println("State value", name, "was not", value, "as expected")
+		t.FailNow()
+	}
+}
+
+func checkQuery(t *testing.T, stub *MockCallerStub, function string, args []string, value string) {
+	bytes, err := stub.MockQuery(function, args)
+	if err != nil {
+		fmt.Println("Query", function, "failed", err)
+		t.FailNow()
+	}
+	if bytes == nil {
+		fmt.Println("Query", function, "failed to get value")
+		t.FailNow()
+	}
+	if string(bytes) != value {
+		fmt.Println("Query value", string(bytes), "was not", value, "as expected")
+		t.FailNow()
+	}
+}
+
+func checkInvoke(t *testing.T, stub *MockCallerStub, function string, args []string) {
+	_, err := stub.MockInvoke("1", function, args)
+	if err != nil {
+		fmt.Println("Invoke", function, args, "failed", err)
+		t.FailNow()
+	}
+}
+
+func TestNettingChaincode_Init(t *testing.T) {
+	log.Info("\n\nInit test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	// calls
+	checkInit(t, stub, []string{})
+}
+
+func TestNettingChaincode_QueryEmptyStats(t *testing.T) {
+	log.Info("\n\nQuery empty stats test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	referenceStats := netting.NettingTableStats{
+		NumberOfCounterParties: 0,
+		NumberOfClaims: 0,
+		MetricL1: -1.0,
+		MetricL2: -1.0,
+		SumH: 0.0,
+	}
+	referenceBytes, _ := json.Marshal(referenceStats)
+	// calls
+	checkInit(t, stub, []string{})
+	checkQuery(t, stub, "Stats", []string{}, string(referenceBytes))
+}
+
+func TestNettingChaincode_Query3NodesStats(t *testing.T) {
+	log.Info("\n\nQuery 3 nodes stats test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	referenceStats := netting.NettingTableStats{
+		NumberOfCounterParties: 3,
+		NumberOfClaims: 0,
+		MetricL1: 0.0,
+		MetricL2: 0.0,
+		SumH: 0.0,
+	}
+	referenceBytes, _ := json.Marshal(referenceStats)
+	//calls
+	checkInit(t, stub, []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkQuery(t, stub, "Stats", []string{}, string(referenceBytes))
+}
+
+func TestNettingChaincode_Query3NodesWithClaim(t *testing.T) {
+	log.Info("\n\nQuery 3 nodes with claim stats test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	referenceString := "[{\"f\":1,\"t\":2,\"v\":3.14}]"
+	//calls
+	checkInit(t, stub, []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "3.14"})
+	checkQuery(t, stub, "Claims", []string{"1"}, referenceString)
+}
+
+func TestNettingChaincode_Query3NodesWith2Claims(t *testing.T) {
+	log.Info("\n\nQuery 3 nodes with claims stats test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	referenceString := "[{\"f\":1,\"t\":2,\"v\":6.28}]"
+	//calls
+	checkInit(t, stub, []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "3.14"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "3.14"})
+	checkQuery(t, stub, "Claims", []string{"1"}, referenceString)
+}
+
+func TestNettingChaincode_testReferenceTable(t *testing.T) {
+	log.Info("\n\nReference table stats test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	referenceStats := netting.NettingTableStats{
+		NumberOfCounterParties: 10,
+		NumberOfClaims: 44,
+		MetricL1: 53.44444444444444,
+		MetricL2: 64.00086804966875,
+		SumH: 0.0,
+	}
+	referenceBytes, _ := json.Marshal(referenceStats)
+	//calls
+	checkInit(t, stub, []string{})
+	// adds 10
+	for i := 0; i < 10; i++ {
+		checkInvoke(t, stub, "AddCounterParty", []string{})
+	}
+
+	for _, args := range referenceClaimArgs {
+		checkInvoke(t, stub, "AddClaim", args)
+	}
+
+	checkQuery(t, stub, "Stats", []string{}, string(referenceBytes))
+}
+
+func TestNettingChaincode_testNetting(t *testing.T) {
+	log.Info("\n\nReference table + Netting stats test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	//calls
+	checkInit(t, stub, []string{})
+	// adds 10
+	for i := 0; i < 10; i++ {
+		checkInvoke(t, stub, "AddCounterParty", []string{})
+	}
+
+	for _, args := range referenceClaimArgs {
+		checkInvoke(t, stub, "AddClaim", args)
+	}
+	checkInvoke(t, stub, "CloseCycle", []string{})
+	checkInvoke(t, stub, "RunNetting", []string{})
+
+	initial := netting.NettingTableStats{
+		NumberOfCounterParties: 10,
+		NumberOfClaims: 44,
+		MetricL1: 53.44444444444444,
+		MetricL2: 64.00086804966875,
+		SumH: 0.0,
+	}
+
+	var stats netting.NettingTableStats
+	bts, _ := stub.MockQuery("Stats", []string{})
+	_ = json.Unmarshal(bts, &stats)
+	if stats.SumH != 0.0 ||
+		stats.NumberOfCounterParties != initial.NumberOfCounterParties ||
+		stats.NumberOfClaims >= initial.NumberOfClaims ||
+		stats.MetricL1 >= initial.MetricL1 ||
+		stats.MetricL2 >= initial.MetricL2 {
+		t.FailNow()
+	}
+}
+
+type mockEvent struct {
+	name    string
+	payload string
+}
+
+// Records the events emitted by the chaincode until restore is called
+func recordEvents() (events *[]mockEvent, restore func()) {
+	events = &[]mockEvent{}
+	original := setEvent
+	setEvent = func(stub shim.ChaincodeStubInterface, name string, payload []byte) error {
+		*events = append(*events, mockEvent{name: name, payload: string(payload)})
+		return nil
+	}
+	return events, func() { setEvent = original }
+}
+
+func checkEvents(t *testing.T, events *[]mockEvent, count int) {
+	if len(*events) != count {
+		fmt.Println("Number of events", len(*events), "was not", count, "as expected")
+		t.FailNow()
+	}
+}
+
+func checkLastEvent(t *testing.T, events *[]mockEvent, name string, payload string) {
+	if len(*events) == 0 {
+		fmt.Println("No event was emitted, expected", name)
+		t.FailNow()
+	}
+	last := (*events)[len(*events)-1]
+	if last.name != name || last.payload != payload {
+		fmt.Println("Event", last.name, last.payload, "was not", name, payload, "as expected")
+		t.FailNow()
+	}
+}
+
+func TestNettingChaincode_Events(t *testing.T) {
+	log.Info("\n\nEvents test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	events, restore := recordEvents()
+	defer restore()
+	//calls
+	checkInit(t, stub, []string{})
+	checkEvents(t, events, 0)
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkLastEvent(t, events, "CounterPartyRegistered", "{\"counter_party_id\":0}")
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkLastEvent(t, events, "CounterPartyRegistered", "{\"counter_party_id\":2}")
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "10"})
+	checkLastEvent(t, events, "ClaimAdded", "{\"f\":0,\"t\":1,\"v\":10}")
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"2", "0", "10"})
+	checkInvoke(t, stub, "CancelClaim", []string{"2", "0", "2.5"})
+	checkLastEvent(t, events, "ClaimCancelled", "{\"f\":2,\"t\":0,\"v\":2.5}")
+	checkEvents(t, events, 7)
+
+	// Ignored claims do not emit events
+	checkInvoke(t, stub, "AddClaim", []string{"0", "0", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"0", "7", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "-10"})
+	checkEvents(t, events, 7)
+
+	// Cannot cancel more than is claimed
+	if _, err := stub.MockInvoke("1", "CancelClaim", []string{"2", "0", "100"}); err == nil {
+		fmt.Println("CancelClaim of more than the claim value did not fail")
+		t.FailNow()
+	}
+	checkEvents(t, events, 7)
+
+	checkInvoke(t, stub, "CloseCycle", []string{})
+	checkLastEvent(t, events, "CycleClosed", "{\"open\":2,\"frozen\":1,\"cut_off\":\"\",\"netted\":false}")
+	checkInvoke(t, stub, "RunNetting", []string{})
+	checkLastEvent(t, events, "NettingCompleted",
+		"{\"before\":{\"number_of_counter_parties\":3,\"number_of_claims\":3,\"metric_l1\":9.166666666666666,\"metric_l2\":9.242113755341181,\"sum_of_h\":0}," +
+			"\"after\":{\"number_of_counter_parties\":3,\"number_of_claims\":2,\"metric_l1\":1.6666666666666667,\"metric_l2\":2.041241452319315,\"sum_of_h\":0}," +
+			"\"report\":{\"algorithm\":\"cycles\",\"claims_before\":3,\"claims_after\":2,\"gross_before\":27.5,\"gross_after\":5,\"steps\":1,\"truncated\":false,\"participants\":[0,1,2]}}")
+}
+
+func checkInvokeFails(t *testing.T, stub *MockCallerStub, function string, args []string) {
+	_, err := stub.MockInvoke("1", function, args)
+	if err == nil {
+		fmt.Println("Invoke", function, args, "did not fail as expected")
+		t.FailNow()
+	}
+}
+
+func TestNettingChaincode_Settlements(t *testing.T) {
+	log.Info("\n\nSettlements test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	//calls
+	checkInit(t, stub, []string{})
+	// Every counter party registers itself
+	parties := []*MockCallerStub{stub.as("party 0"), stub.as("party 1"), stub.as("party 2")}
+	for _, party := range parties {
+		checkInvoke(t, party, "AddCounterParty", []string{})
+	}
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"2", "0", "7.5"})
+	checkQuery(t, stub, "Settlements", []string{}, "[]")
+	checkInvoke(t, stub, "CloseCycle", []string{})
+
+	checkInvokeFails(t, stub, "RunNetting", []string{"EUR", "30.09.2016"})
+	checkInvoke(t, stub, "RunNetting", []string{"EUR", "2016-09-30"})
+	checkQuery(t, stub, "Settlements", []string{"pending"}, "[" +
+		"{\"id\":0,\"payer\":1,\"payee\":0,\"amount\":2.5,\"currency\":\"EUR\",\"value_date\":\"2016-09-30\",\"status\":\"pending\",\"confirmed_by_payer\":false,\"confirmed_by_payee\":false}," +
+		"{\"id\":1,\"payer\":2,\"payee\":1,\"amount\":2.5,\"currency\":\"EUR\",\"value_date\":\"2016-09-30\",\"status\":\"pending\",\"confirmed_by_payer\":false,\"confirmed_by_payee\":false}]")
+	// Nothing can be netted until the instructions are settled
+	checkInvoke(t, stub, "CloseCycle", []string{})
+	checkInvokeFails(t, stub, "RunNetting", []string{})
+
+	// Only a counter party itself confirms or fails
+	checkInvokeFails(t, parties[0], "ConfirmSettlement", []string{"0", "1"})
+	checkInvokeFails(t, stub, "ConfirmSettlement", []string{"0", "1"})
+	checkInvokeFails(t, parties[1], "FailSettlement", []string{"1", "2"})
+
+	// Both sides have to confirm, the claim stays until then
+	checkInvoke(t, parties[1], "ConfirmSettlement", []string{"0", "1"})
+	checkQuery(t, stub, "Claims", []string{"0"}, "[{\"f\":0,\"t\":1,\"v\":2.5}]")
+	checkInvokeFails(t, parties[2], "ConfirmSettlement", []string{"0", "2"})
+	checkInvoke(t, parties[0], "ConfirmSettlement", []string{"0", "0"})
+	checkQuery(t, stub, "Claims", []string{"0"}, "[]")
+	checkInvokeFails(t, parties[0], "ConfirmSettlement", []string{"0", "0"})
+
+	// Failed claims are netted and settled again
+	checkInvoke(t, parties[2], "FailSettlement", []string{"1", "2"})
+	checkQuery(t, stub, "Claims", []string{"2"}, "[{\"f\":2,\"t\":1,\"v\":-2.5}]")
+	checkQuery(t, stub, "Settlements", []string{"pending"}, "[]")
+	checkInvoke(t, stub, "RunNetting", []string{"EUR", "2016-10-03"})
+	checkQuery(t, stub, "Settlements", []string{"pending"}, "[" +
+		"{\"id\":2,\"payer\":2,\"payee\":1,\"amount\":2.5,\"currency\":\"EUR\",\"value_date\":\"2016-10-03\",\"status\":\"pending\",\"confirmed_by_payer\":false,\"confirmed_by_payee\":false}]")
+	checkQuery(t, stub, "Settlements", []string{"confirmed"}, "[" +
+		"{\"id\":0,\"payer\":1,\"payee\":0,\"amount\":2.5,\"currency\":\"EUR\",\"value_date\":\"2016-09-30\",\"status\":\"confirmed\",\"confirmed_by_payer\":true,\"confirmed_by_payee\":true}]")
+}
+
+
+func TestNettingChaincode_Cycles(t *testing.T) {
+	log.Info("\n\nCycles test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	//calls
+	checkInit(t, stub, []string{})
+	checkQuery(t, stub, "Cycle", []string{}, "{\"open\":1,\"frozen\":0,\"cut_off\":\"\",\"netted\":false}")
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "10"})
+
+	// Nothing to net before the cut-off
+	checkInvokeFails(t, stub, "RunNetting", []string{})
+	checkInvoke(t, stub, "CloseCycle", []string{})
+	checkQuery(t, stub, "Cycle", []string{}, "{\"open\":2,\"frozen\":1,\"cut_off\":\"\",\"netted\":false}")
+
+	// After the cut-off claims go into the next cycle and frozen ones cannot be cancelled
+	checkInvoke(t, stub, "AddClaim", []string{"2", "0", "10"})
+	checkInvokeFails(t, stub, "CancelClaim", []string{"0", "1", "10"})
+	checkInvoke(t, stub, "CancelClaim", []string{"2", "0", "5"})
+	checkInvoke(t, stub, "AddClaim", []string{"2", "0", "5"})
+
+	// Queries show all outstanding claims
+	threeClaims := "{\"number_of_counter_parties\":3,\"number_of_claims\":3,\"metric_l1\":10,\"metric_l2\":10,\"sum_of_h\":0}"
+	checkQuery(t, stub, "Stats", []string{}, threeClaims)
+
+	// The cycle 0 -> 1 -> 2 -> 0 is not complete in the frozen claims
+	checkInvoke(t, stub, "RunNetting", []string{})
+	checkInvokeFails(t, stub, "RunNetting", []string{})
+	checkQuery(t, stub, "Stats", []string{}, threeClaims)
+	checkInvoke(t, stub, "FailSettlement", []string{"0", "0"})
+	checkInvoke(t, stub, "FailSettlement", []string{"1", "1"})
+
+	// It is in the next one
+	checkInvoke(t, stub, "CloseCycle", []string{})
+	checkInvoke(t, stub, "RunNetting", []string{})
+	checkQuery(t, stub, "Stats", []string{},
+		"{\"number_of_counter_parties\":3,\"number_of_claims\":0,\"metric_l1\":0,\"metric_l2\":0,\"sum_of_h\":0}")
+	checkQuery(t, stub, "Cycle", []string{}, "{\"open\":3,\"frozen\":2,\"cut_off\":\"\",\"netted\":true}")
+}
+
+
+func TestNettingChaincode_IdempotentClaims(t *testing.T) {
+	log.Info("\n\nIdempotent claims test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	events, restore := recordEvents()
+	defer restore()
+	receipt := "{\"f\":1,\"t\":2,\"v\":3.14,\"cycle\":1,\"applied\":true,\"key\":\"gateway-42\"}"
+	//calls
+	checkInit(t, stub, []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	for i := 0; i < 3; i++ {
+		bytes, err := stub.MockInvoke("1", "AddClaim", []string{"1", "2", "3.14", "gateway-42"})
+		if err != nil || string(bytes) != receipt {
+			fmt.Println("AddClaim retry", i, "returned", string(bytes), err, "instead of", receipt)
+			t.FailNow()
+		}
+	}
+	checkQuery(t, stub, "Claims", []string{"1"}, "[{\"f\":1,\"t\":2,\"v\":3.14}]")
+	checkEvents(t, events, 4)
+
+	// The key belongs to the first claim
+	checkInvokeFails(t, stub, "AddClaim", []string{"1", "2", "6.28", "gateway-42"})
+
+	// Claims without a key are always applied
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "3.14"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "3.14", "gateway-43"})
+	checkQuery(t, stub, "Claims", []string{"1"}, "[{\"f\":1,\"t\":2,\"v\":9.42}]")
+
+	// Keys are forgotten with the claims, every time the state is cleared
+	for i := 0; i < 2; i++ {
+		checkInvoke(t, stub, "Clear", []string{})
+		for j := 0; j < 3; j++ {
+			checkInvoke(t, stub, "AddCounterParty", []string{})
+		}
+		bytes, err := stub.MockInvoke("1", "AddClaim", []string{"1", "2", "3.14", "gateway-42"})
+		if err != nil || string(bytes) != receipt {
+			fmt.Println("AddClaim after Clear", i, "returned", string(bytes), err, "instead of", receipt)
+			t.FailNow()
+		}
+		checkQuery(t, stub, "Claims", []string{"1"}, "[{\"f\":1,\"t\":2,\"v\":3.14}]")
+	}
+}
+
+// Only the fields a test is about are checked, new ones do not break it
+func queryConfig(t *testing.T, stub *MockCallerStub) *config {
+	bytes, err := stub.MockQuery("Config", []string{})
+	if err != nil {
+		fmt.Println("Query Config failed", err)
+		t.FailNow()
+	}
+	var cfg config
+	if err := json.Unmarshal(bytes, &cfg); err != nil {
+		fmt.Println("Config", string(bytes), "failed to decode", err)
+		t.FailNow()
+	}
+	return &cfg
+}
+
+func TestNettingChaincode_Config(t *testing.T) {
+	log.Info("\n\nConfig test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	//calls
+	checkInit(t, stub, []string{"{\"precision\":1,\"zero_tolerance\":0.5,\"max_counter_parties\":3,\"max_cycle_length\":2,\"currencies\":[\"EUR\",\"CHF\"]}"})
+	cfg := queryConfig(t, stub)
+	if cfg.Algorithm != algorithmCycles || cfg.Precision != 1 || cfg.ZeroTolerance != 0.5 ||
+		cfg.MaxCounterParties != 3 || cfg.MaxCycleLength != 2 || fmt.Sprint(cfg.Currencies) != "[EUR CHF]" {
+		fmt.Printf("Config %+v was not as expected\n", *cfg)
+		t.FailNow()
+	}
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvokeFails(t, stub, "AddCounterParty", []string{})
+
+	// Rounded to precision, zero within tolerance
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "3.14"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "0.4"})
+	checkQuery(t, stub, "Claims", []string{"0"}, "[{\"f\":0,\"t\":1,\"v\":3.1}]")
+	checkQuery(t, stub, "Claims", []string{"2"}, "[]")
+
+	// Cycles of 3 are longer than allowed
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "3.1"})
+	checkInvoke(t, stub, "AddClaim", []string{"2", "0", "3.1"})
+	checkInvoke(t, stub, "CloseCycle", []string{})
+	checkInvokeFails(t, stub, "RunNetting", []string{"USD"})
+	checkInvoke(t, stub, "RunNetting", []string{})
+	checkQuery(t, stub, "Settlements", []string{}, "[" +
+		"{\"id\":0,\"payer\":1,\"payee\":0,\"amount\":3.1,\"currency\":\"EUR\",\"value_date\":\"\",\"status\":\"pending\",\"confirmed_by_payer\":false,\"confirmed_by_payee\":false}," +
+		"{\"id\":1,\"payer\":2,\"payee\":1,\"amount\":3.1,\"currency\":\"EUR\",\"value_date\":\"\",\"status\":\"pending\",\"confirmed_by_payer\":false,\"confirmed_by_payee\":false}," +
+		"{\"id\":2,\"payer\":0,\"payee\":2,\"amount\":3.1,\"currency\":\"EUR\",\"value_date\":\"\",\"status\":\"pending\",\"confirmed_by_payer\":false,\"confirmed_by_payee\":false}]")
+
+	// Invalid documents are rejected, omitted fields are kept
+	checkInvokeFails(t, stub, "UpdateConfig", []string{"{\"algorithm\":\"magic\"}"})
+	checkInvokeFails(t, stub, "UpdateConfig", []string{"{\"zero_tolerance\":-1}"})
+	checkInvokeFails(t, stub, "UpdateConfig", []string{"{\"runs_kept\":0}"})
+	checkInvoke(t, stub, "UpdateConfig", []string{"{\"max_counter_parties\":0,\"max_cycle_length\":0}"})
+	cfg = queryConfig(t, stub)
+	if cfg.Precision != 1 || cfg.ZeroTolerance != 0.5 ||
+		cfg.MaxCounterParties != 0 || cfg.MaxCycleLength != 0 || fmt.Sprint(cfg.Currencies) != "[EUR CHF]" {
+		fmt.Printf("Config %+v was not as expected\n", *cfg)
+		t.FailNow()
+	}
+
+	// Clear keeps the configuration
+	checkInvoke(t, stub, "Clear", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "2.75"})
+	checkQuery(t, stub, "Claims", []string{"0"}, "[{\"f\":0,\"t\":1,\"v\":2.8}]")
+}
+
+func TestNettingChaincode_Admin(t *testing.T) {
+	log.Info("\n\nAdmin test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	//calls
+	checkInit(t, stub, []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "UpdateConfig", []string{"{\"precision\":1}"})
+	checkInvoke(t, stub, "SetLimit", []string{"gross", "0", "100"})
+	checkInvoke(t, stub, "Repair", []string{})
+
+	// Only the caller of Init signs as the admin
+	other := stub.as("other")
+	checkInvokeFails(t, other, "UpdateConfig", []string{"{\"precision\":2}"})
+	checkInvokeFails(t, other, "SetLimit", []string{"gross", "0", "200"})
+	checkInvokeFails(t, other, "Repair", []string{})
+	if cfg := queryConfig(t, stub); cfg.Precision != 1 {
+		fmt.Println("Precision", cfg.Precision, "was changed by another caller")
+		t.FailNow()
+	}
+
+	// Without caller certificates there is no admin
+	plain := shim.NewMockStub("netting", scc)
+	plain.MockInit("1", "init", []string{})
+	if _, err := plain.MockInvoke("1", "UpdateConfig", []string{"{\"precision\":2}"}); err == nil {
+		fmt.Println("UpdateConfig without a caller certificate did not fail")
+		t.FailNow()
+	}
+}
+
+// Claims of testdata/reference.txt, the netting library's example01.txt,
+// as the scenario adds them
+var referenceClaimArgs = loadReferenceClaimArgs()
+var referenceClaims = toClaims(referenceClaimArgs)
+
+func loadReferenceClaimArgs() [][]string {
+	file, err := os.Open(filepath.Join("testdata", "reference.txt"))
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	scenario, err := ParseScenario("reference.txt", file)
+	if err != nil {
+		panic(err)
+	}
+	args := [][]string{}
+	for _, step := range scenario.steps {
+		if step.function == "AddClaim" && !step.query {
+			args = append(args, step.args)
+		}
+	}
+	return args
+}
+
+func toClaims(args [][]string) []claim {
+	claims := []claim{}
+	for _, a := range args {
+		c, err := parseClaim(a)
+		if err != nil {
+			panic(err)
+		}
+		claims = append(claims, c)
+	}
+	return claims
+}
+
+func referenceTable() Table {
+	table := netting.NettingTable{}
+	table.Init()
+	for i := 0; i < 10; i++ {
+		table.AddCounterParty()
+	}
+	for _, c := range referenceClaims {
+		table.AddClaim(c.From, c.To, c.Value)
+	}
+	return &table
+}
+
+func TestNetters_ReferenceTable(t *testing.T) {
+	log.Info("\n\nNetting algorithms on reference table test")
+	table := referenceTable()
+	positions := netPositions(table)
+	cfg := defaultConfig()
+
+	reports := map[string]*nettingReport{}
+	for algorithm := range netters {
+		result, report, err := optimize(table, cfg, algorithm, nil)
+		if err != nil {
+			fmt.Println("Algorithm", algorithm, "failed", err)
+			t.FailNow()
+		}
+		for id, p := range netPositions(result) {
+			if math.Abs(p-positions[id]) > 1e-6 {
+				fmt.Println("Algorithm", algorithm, "changed position of", id, "from", positions[id], "to", p)
+				t.FailNow()
+			}
+		}
+		if report.GrossAfter > report.GrossBefore || report.ClaimsAfter != len(getAllClaims(result)) {
+			fmt.Println("Algorithm", algorithm, "report", *report, "is wrong")
+			t.FailNow()
+		}
+		reports[algorithm] = report
+	}
+
+	bilateral := reports[algorithmBilateral]
+	if bilateral.ClaimsAfter != 44 || bilateral.GrossAfter != bilateral.GrossBefore {
+		fmt.Println("Bilateral netting changed the table", *bilateral)
+		t.FailNow()
+	}
+	// Optimal on the existing edges, so never worse than cancelling cycles
+	if reports[algorithmMinCostFlow].GrossAfter > reports[algorithmCycles].GrossAfter {
+		fmt.Println("Min-cost flow", *reports[algorithmMinCostFlow], "is worse than", *reports[algorithmCycles])
+		t.FailNow()
+	}
+	// Every payment settles at least one of 10 counter parties, the last one settles two
+	if reports[algorithmPaymentCount].ClaimsAfter > 9 {
+		fmt.Println("Payment count minimisation", *reports[algorithmPaymentCount], "left too many claims")
+		t.FailNow()
+	}
+}
+
+func TestNettingChaincode_NettingAlgorithm(t *testing.T) {
+	log.Info("\n\nNetting algorithm per call and per configuration test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	//calls
+	checkInit(t, stub, []string{"{\"algorithm\":\"paymentcount\"}"})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "10"})
+	checkInvoke(t, stub, "CloseCycle", []string{})
+
+	checkInvokeFails(t, stub, "RunNetting", []string{"", "", "magic"})
+	bytes, err := stub.MockInvoke("1", "RunNetting", []string{})
+	report := "{\"algorithm\":\"paymentcount\",\"claims_before\":2,\"claims_after\":1,\"gross_before\":20,\"gross_after\":10,\"steps\":1,\"truncated\":false,\"participants\":[0,1,2]}"
+	if err != nil || string(bytes) != report {
+		fmt.Println("RunNetting returned", string(bytes), err, "instead of", report)
+		t.FailNow()
+	}
+	// 2 owes 0 now, though they never traded
+	checkQuery(t, stub, "Claims", []string{"0"}, "[{\"f\":0,\"t\":2,\"v\":10}]")
+}
+func TestNettingChaincode_SubmitNettingResult(t *testing.T) {
+	log.Info("\n\nSubmitted netting result test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	//calls
+	checkInit(t, stub, []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"2", "0", "4"})
+	checkInvoke(t, stub, "CloseCycle", []string{})
+
+	// Malformed, positions changed, claims new or larger, both directions, no improvement
+	checkInvokeFails(t, stub, "SubmitNettingResult", []string{"{"})
+	checkInvokeFails(t, stub, "SubmitNettingResult", []string{"{\"Nodes\":[0,1],\"Edges\":[]}"})
+	checkInvokeFails(t, stub, "SubmitNettingResult", []string{"{\"Nodes\":[0,1,2],\"Edges\":[]}"})
+	checkInvokeFails(t, stub, "SubmitNettingResult", []string{"{\"Nodes\":[0,1,2],\"Edges\":[{\"f\":0,\"t\":2,\"v\":6}]}"})
+	checkInvokeFails(t, stub, "SubmitNettingResult", []string{"{\"Nodes\":[0,1,2],\"Edges\":[{\"f\":0,\"t\":1,\"v\":16},{\"f\":1,\"t\":2,\"v\":10},{\"f\":2,\"t\":0,\"v\":10}]}"})
+	checkInvokeFails(t, stub, "SubmitNettingResult", []string{"{\"Nodes\":[0,1,2],\"Edges\":[{\"f\":0,\"t\":1,\"v\":7},{\"f\":1,\"t\":0,\"v\":1},{\"f\":1,\"t\":2,\"v\":6}]}"})
+	checkInvokeFails(t, stub, "SubmitNettingResult", []string{"{\"Nodes\":[0,1,2],\"Edges\":[{\"f\":0,\"t\":1,\"v\":10},{\"f\":1,\"t\":2,\"v\":10},{\"f\":2,\"t\":0,\"v\":4}]}"})
+
+	// Only by the admin or an operator
+	result := "{\"Nodes\":[0,1,2],\"Edges\":[{\"f\":0,\"t\":1,\"v\":6},{\"f\":1,\"t\":2,\"v\":6}]}"
+	checkInvokeFails(t, stub.as("operator"), "SubmitNettingResult", []string{result})
+	checkInvoke(t, stub, "UpdateConfig", []string{"{\"operators\":[\"b3BlcmF0b3I=\"]}"})
+	checkInvokeFails(t, stub.as("other"), "SubmitNettingResult", []string{result})
+
+	// The cycle cancelled off-chain
+	bytes, err := stub.as("operator").MockInvoke("1", "SubmitNettingResult", []string{result})
+	report := "{\"algorithm\":\"submitted\",\"claims_before\":3,\"claims_after\":2,\"gross_before\":24,\"gross_after\":12,\"steps\":0,\"truncated\":false,\"participants\":[0,1,2]," +
+		"\"submitter\":\"" + fingerprint([]byte("operator")) + "\"}"
+	if err != nil || string(bytes) != report {
+		fmt.Println("SubmitNettingResult returned", string(bytes), err, "instead of", report)
+		t.FailNow()
+	}
+	checkQuery(t, stub, "Stats", []string{},
+		"{\"number_of_counter_parties\":3,\"number_of_claims\":2,\"metric_l1\":4,\"metric_l2\":4.898979485566356,\"sum_of_h\":0}")
+	checkQuery(t, stub, "Cycle", []string{}, "{\"open\":2,\"frozen\":1,\"cut_off\":\"\",\"netted\":true}")
+	checkInvokeFails(t, stub, "SubmitNettingResult", []string{"{\"Nodes\":[0,1,2],\"Edges\":[{\"f\":0,\"t\":1,\"v\":6},{\"f\":1,\"t\":2,\"v\":6}]}"})
+}
+
+// Every pair of counter parties has claims both ways before AddClaim nets them,
+// far too many cycles to enumerate
+func denseTable(N int) Table {
+	table := &netting.NettingTable{}
+	table.Init()
+	for i := 0; i < N; i++ {
+		table.AddCounterParty()
+	}
+	for i := 0; i < N; i++ {
+		for j := 0; j < N; j++ {
+			if i != j {
+				table.AddClaim(i, j, float64((i*7+j*13)%10+1))
+			}
+		}
+	}
+	return table
+}
+
+func TestCancelCycles_Budget(t *testing.T) {
+	log.Info("\n\nCycle cancellation budget test")
+	table := denseTable(40)
+	positions := netPositions(table)
+	cfg := defaultConfig()
+	cfg.MaxCycleLength = 4
+	cfg.CycleBudget = 10000
+
+	result, report, err := optimize(table, cfg, algorithmCycles, nil)
+	if err != nil || !report.Truncated || report.Steps == 0 || report.GrossAfter >= report.GrossBefore {
+		fmt.Println("Budgeted netting returned", report, err)
+		t.FailNow()
+	}
+	for id, p := range netPositions(result) {
+		if math.Abs(p-positions[id]) > 1e-6 {
+			fmt.Println("Budgeted netting changed position of", id, "from", positions[id], "to", p)
+			t.FailNow()
+		}
+	}
+
+	// The reference table fits in the budget
+	_, report, _ = optimize(referenceTable(), cfg, algorithmCycles, nil)
+	if report.Truncated {
+		fmt.Println("Reference table netting", *report, "was truncated")
+		t.FailNow()
+	}
+}
+
+func TestCancelCycles_Components(t *testing.T) {
+	log.Info("\n\nCycle cancellation by components test")
+	// Two cycles joined by a claim which is in none
+	table := &netting.NettingTable{}
+	table.Init()
+	for i := 0; i < 6; i++ {
+		table.AddCounterParty()
+	}
+	for _, c := range []claim{{0, 1, 5}, {1, 2, 5}, {2, 0, 5}, {2, 3, 7}, {3, 4, 3}, {4, 5, 3}, {5, 3, 4}} {
+		table.AddClaim(c.From, c.To, c.Value)
+	}
+
+	result, cancelled, truncated := cancelCycles(table, 0, 0)
+	if cancelled != 2 || truncated {
+		fmt.Println("Cancelled", cancelled, "cycles, truncated", truncated)
+		t.FailNow()
+	}
+	expected := []claim{{2, 3, 7}, {5, 3, 1}}
+	if fmt.Sprint(getAllClaims(result)) != fmt.Sprint(expected) {
+		fmt.Println("Claims", getAllClaims(result), "instead of", expected)
+		t.FailNow()
+	}
+
+	// Too long for the limit
+	_, cancelled, _ = cancelCycles(table, 2, 0)
+	if cancelled != 0 {
+		fmt.Println("Cancelled", cancelled, "cycles longer than 2")
+		t.FailNow()
+	}
+}
+
+func TestCancelCycles_UsedUpClaim(t *testing.T) {
+	log.Info("\n\nCycle cancellation of used up claims test")
+	// Cancelling 0 1 2 3 uses up 0 -> 1, which 0 1 2 3 4 goes through too
+	table := newTable(5, false)
+	for _, c := range []claim{{0, 1, 1}, {1, 2, 5}, {2, 3, 5}, {3, 0, 5}, {3, 4, 5}, {4, 0, 5}} {
+		table.AddClaim(c.From, c.To, c.Value)
+	}
+	result, cancelled, _ := cancelCycles(table, 0, 0)
+	expected := []claim{{1, 2, 4}, {2, 3, 4}, {3, 0, 4}, {3, 4, 5}, {4, 0, 5}}
+	if cancelled != 1 || fmt.Sprint(getAllClaims(result)) != fmt.Sprint(expected) {
+		fmt.Println("Cancelled", cancelled, "cycles, claims", getAllClaims(result), "instead of", expected)
+		t.FailNow()
+	}
+
+	// Cycles of any length are only searched for within the default budget
+	_, report, _ := optimize(randomTable(40, 0.9, false), defaultConfig(), algorithmCycles, nil)
+	if !report.Truncated || report.Steps > defaultCycleBudget+1 {
+		fmt.Println("Netting with the default budget returned", *report)
+		t.FailNow()
+	}
+}
+
+func TestConfig_Round(t *testing.T) {
+	log.Info("\n\nRounding test")
+	cfg := defaultConfig()
+	if cfg.round(2.675) != 2.675 {
+		fmt.Println("Amounts were rounded by default")
+		t.FailNow()
+	}
+	cfg.Precision = 2
+	for _, c := range []struct{ rounding string; value, expected float64 }{
+		{roundingHalfEven, 0.125, 0.12}, {roundingHalfEven, 0.375, 0.38}, {roundingHalfEven, -0.125, -0.12},
+		{roundingHalfEven, 0.5e-13, 0.0}, {roundingHalfEven, 100.0 - 1e-13, 100.0},
+		{roundingHalfEven, 2.675, 2.68}, {roundingHalfEven, 1.015, 1.02}, {roundingHalfEven, 1.025, 1.02},
+		{roundingHalfEven, 0.0051, 0.01}, {roundingHalfEven, 12345.6, 12345.6}, {roundingHalfEven, 0.004, 0.0},
+		{roundingHalfAway, 0.125, 0.13}, {roundingHalfAway, 0.375, 0.38}, {roundingHalfAway, -0.125, -0.13},
+	} {
+		cfg.Rounding = c.rounding
+		if rounded := cfg.round(c.value); rounded != c.expected {
+			fmt.Println("Rounding", c.rounding, "of", c.value, "is", rounded, "instead of", c.expected)
+			t.FailNow()
+		}
+	}
+}
+
+func TestNormalizeTable_Dust(t *testing.T) {
+	log.Info("\n\nDust test")
+	table := &netting.NettingTable{}
+	table.Init()
+	for i := 0; i < 4; i++ {
+		table.AddCounterParty()
+	}
+	for _, c := range []claim{{0, 1, 10}, {1, 2, 0.03}, {2, 0, 0.04}} {
+		table.AddClaim(c.From, c.To, c.Value)
+	}
+	cfg := defaultConfig()
+	cfg.Precision = 2
+	cfg.ZeroTolerance = 0.05
+
+	expected := []claim{{0, 1, 10}}
+	if claims := getAllClaims(normalizeTable(table, cfg)); fmt.Sprint(claims) != fmt.Sprint(expected) {
+		fmt.Println("Dropped dust claims", claims, "instead of", expected)
+		t.FailNow()
+	}
+
+	// Positions are kept, the dust account nets what it is owed and owes
+	cfg.Dust = dustSweep
+	cfg.DustAccount = 3
+	expected = []claim{{0, 1, 10}, {1, 3, 0.03}, {2, 3, 0.01}, {3, 0, 0.04}}
+	if claims := getAllClaims(normalizeTable(table, cfg)); fmt.Sprint(claims) != fmt.Sprint(expected) {
+		fmt.Println("Swept dust claims", claims, "instead of", expected)
+		t.FailNow()
+	}
+
+	// Nowhere to sweep to
+	cfg.DustAccount = 4
+	expected = []claim{{0, 1, 10}}
+	if claims := getAllClaims(normalizeTable(table, cfg)); fmt.Sprint(claims) != fmt.Sprint(expected) {
+		fmt.Println("Dust claims", claims, "instead of", expected)
+		t.FailNow()
+	}
+}
+
+func TestNettingChaincode_SubGroupNetting(t *testing.T) {
+	log.Info("\n\nSub-group netting test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	//calls
+	checkInit(t, stub, []string{})
+	for i := 0; i < 4; i++ {
+		checkInvoke(t, stub, "AddCounterParty", []string{})
+	}
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"2", "0", "4"})
+	checkInvoke(t, stub, "AddClaim", []string{"2", "3", "5"})
+	checkInvoke(t, stub, "AddClaim", []string{"3", "0", "5"})
+	checkInvoke(t, stub, "CloseCycle", []string{})
+
+	for _, participants := range []string{"0", "0,0", "0,9", "0,a"} {
+		checkInvokeFails(t, stub, "RunNetting", []string{"", "", "", participants})
+	}
+
+	// 3 stays out, so the cycle through it is not cancelled
+	bytes, err := stub.MockInvoke("1", "RunNetting", []string{"", "", "", "2, 0,1"})
+	report := "{\"algorithm\":\"cycles\",\"claims_before\":3,\"claims_after\":2,\"gross_before\":24,\"gross_after\":12," +
+		"\"steps\":1,\"truncated\":false,\"participants\":[0,1,2]}"
+	if err != nil || string(bytes) != report {
+		fmt.Println("RunNetting returned", string(bytes), err, "instead of", report)
+		t.FailNow()
+	}
+	for id, expected := range map[string]string{
+		"3": "[{\"f\":3,\"t\":0,\"v\":5},{\"f\":3,\"t\":2,\"v\":-5}]",
+		"1": "[{\"f\":1,\"t\":2,\"v\":6},{\"f\":1,\"t\":0,\"v\":-6}]",
+	} {
+		// In no particular order
+		bytes, _ := stub.MockQuery("Claims", []string{id})
+		if sortedClaims(bytes) != sortedClaims([]byte(expected)) {
+			fmt.Println("Claims of", id, string(bytes), "instead of", expected)
+			t.FailNow()
+		}
+	}
+
+	// Only claims between the participants are settled
+	checkQuery(t, stub, "Settlements", []string{}, "[" +
+		"{\"id\":0,\"payer\":1,\"payee\":0,\"amount\":6,\"currency\":\"USD\",\"value_date\":\"\",\"status\":\"pending\",\"confirmed_by_payer\":false,\"confirmed_by_payee\":false}," +
+		"{\"id\":1,\"payer\":2,\"payee\":1,\"amount\":6,\"currency\":\"USD\",\"value_date\":\"\",\"status\":\"pending\",\"confirmed_by_payer\":false,\"confirmed_by_payee\":false}]")
+}
+
+func TestNettingChaincode_ExposureLimits(t *testing.T) {
+	log.Info("\n\nExposure limits test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	//calls
+	checkInit(t, stub, []string{})
+	for i := 0; i < 3; i++ {
+		checkInvoke(t, stub, "AddCounterParty", []string{})
+	}
+	checkInvokeFails(t, stub, "SetLimit", []string{"total", "1", "15"})
+	checkInvokeFails(t, stub, "SetLimit", []string{"gross", "9", "15"})
+	checkInvokeFails(t, stub, "SetLimit", []string{"gross", "1", "-15"})
+	checkInvokeFails(t, stub, "SetLimit", []string{"pair", "2", "5", "2"})
+	checkInvoke(t, stub, "SetLimit", []string{"gross", "1", "15"})
+	checkInvoke(t, stub, "SetLimit", []string{"net", "2", "8"})
+	checkInvoke(t, stub, "SetLimit", []string{"pair", "2", "5", "0"})
+
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "10"})
+	checkInvokeFails(t, stub, "AddClaim", []string{"2", "1", "6"})
+	// Netted against the claim of 0, so the gross exposure of 1 goes down
+	checkInvoke(t, stub, "AddClaim", []string{"1", "0", "4"})
+	checkInvoke(t, stub, "AddClaim", []string{"2", "1", "6"})
+	checkInvokeFails(t, stub, "AddClaim", []string{"0", "2", "6"})
+	checkInvoke(t, stub, "AddClaim", []string{"0", "2", "5"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "9"})
+	checkInvokeFails(t, stub, "AddClaim", []string{"1", "2", "1"})
+
+	checkQuery(t, stub, "Exposures", []string{"2"}, "[{\"counter_party\":2,\"gross\":8,\"gross_limit\":0," +
+		"\"net\":8,\"net_limit\":8,\"utilisation\":1,\"pairs\":[{\"creditor\":0,\"exposure\":5,\"limit\":5}]}]")
+	checkQuery(t, stub, "Exposures", []string{"1"}, "[{\"counter_party\":1,\"gross\":6,\"gross_limit\":15," +
+		"\"net\":3,\"net_limit\":0,\"utilisation\":0.4,\"pairs\":[]}]")
+
+	// Without the limit the claim goes through
+	checkInvoke(t, stub, "SetLimit", []string{"net", "2", "0"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "1"})
+
+	// Anyone may clear, only the admin sets limits, so they are kept
+	checkInvoke(t, stub.as("participant"), "Clear", []string{})
+	for i := 0; i < 3; i++ {
+		checkInvoke(t, stub, "AddCounterParty", []string{})
+	}
+	checkInvokeFails(t, stub, "AddClaim", []string{"0", "1", "16"})
+	checkInvokeFails(t, stub, "AddClaim", []string{"0", "2", "6"})
+	checkInvoke(t, stub, "AddClaim", []string{"0", "2", "5"})
+}
+
+func TestSequence_Gridlock(t *testing.T) {
+	pending := func(id, payer, payee int, amount float64) settlementInstruction {
+		return settlementInstruction{Id: id, Payer: payer, Payee: payee, Amount: amount, Status: settlementPending}
+	}
+	// 0, 1 and 2 wait for each other, 2 cannot pay 1 anyway, 3 can pay from its balance
+	queue := []settlementInstruction{pending(0, 0, 1, 10), pending(1, 1, 2, 10), pending(2, 2, 0, 10),
+		pending(3, 2, 1, 50), pending(4, 3, 0, 2)}
+	report := sequence(queue, []float64{0, 0, 5, 2}, defaultConfig())
+
+	bytes, _ := json.Marshal(report)
+	expected := "{\"settled\":[" +
+		"{\"id\":4,\"payer\":3,\"payee\":0,\"amount\":2,\"step\":1,\"offset\":false}," +
+		"{\"id\":0,\"payer\":0,\"payee\":1,\"amount\":10,\"step\":2,\"offset\":true}," +
+		"{\"id\":1,\"payer\":1,\"payee\":2,\"amount\":10,\"step\":2,\"offset\":true}," +
+		"{\"id\":2,\"payer\":2,\"payee\":0,\"amount\":10,\"step\":2,\"offset\":true}]," +
+		"\"unsettled\":[{\"id\":3,\"payer\":2,\"payee\":1,\"amount\":50,\"shortfall\":45,\"reason\":\"payer 2 has 5 of 50\"}]," +
+		"\"balances\":[2,0,5,0]}"
+	if string(bytes) != expected {
+		fmt.Println("sequence returned", string(bytes), "instead of", expected)
+		t.FailNow()
+	}
+}
+
+func TestNettingChaincode_Sequence(t *testing.T) {
+	log.Info("\n\nSettlement sequencing test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	//calls
+	checkInit(t, stub, []string{})
+	for i := 0; i < 2; i++ {
+		checkInvoke(t, stub, "AddCounterParty", []string{})
+	}
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "10"})
+	checkInvoke(t, stub, "CloseCycle", []string{})
+	checkInvoke(t, stub, "RunNetting", []string{})
+
+	for _, liquidity := range []string{"", "[4]", "{\"2\":4}", "{\"1\":-4}"} {
+		if _, err := stub.MockQuery("Sequence", []string{liquidity}); err == nil {
+			fmt.Println("Sequence with liquidity", liquidity, "did not fail")
+			t.FailNow()
+		}
+	}
+	checkQuery(t, stub, "Sequence", []string{"{\"1\":4}"}, "{\"settled\":[],\"unsettled\":[" +
+		"{\"id\":0,\"payer\":1,\"payee\":0,\"amount\":10,\"shortfall\":6,\"reason\":\"payer 1 has 4 of 10\"}]," +
+		"\"balances\":[0,4]}")
+	checkQuery(t, stub, "Sequence", []string{"{\"0\":1,\"1\":10}"}, "{\"settled\":[" +
+		"{\"id\":0,\"payer\":1,\"payee\":0,\"amount\":10,\"step\":1,\"offset\":false}],\"unsettled\":[]," +
+		"\"balances\":[11,0]}")
+}
+
+func TestNettingChaincode_ClaimClasses(t *testing.T) {
+	log.Info("\n\nClaim classes test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	//calls
+	checkInit(t, stub, []string{"{\"classes\":[\"trade\",\"tax\"],\"offsets\":[[\"trade\",\"trade\"]]}"})
+	for i := 0; i < 3; i++ {
+		checkInvoke(t, stub, "AddCounterParty", []string{})
+	}
+	for _, cfg := range []string{
+		"{\"classes\":[\"tax\",\"trade\"]}",
+		"{\"offsets\":[[\"trade\",\"fee\"]]}",
+		"{\"classes\":[\"trade\",\"tax\",\"margin\"],\"offsets\":[[\"trade\",\"margin\"]]}",
+		"{\"classes\":[\"trade\",\"tax\",\"margin\"],\"offsets\":[[\"trade\",\"trade\"],[\"tax\",\"tax\"]," +
+			"[\"margin\",\"margin\"],[\"trade\",\"margin\"],[\"margin\",\"tax\"]]}",
+	} {
+		checkInvokeFails(t, stub, "UpdateConfig", []string{cfg})
+	}
+
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"2", "0", "5", "", "trade"})
+	checkInvoke(t, stub, "AddClaim", []string{"2", "0", "10", "k1", "tax"})
+	checkInvokeFails(t, stub, "AddClaim", []string{"2", "0", "10", "k2", "fee"})
+	// The key was used for a tax claim
+	checkInvokeFails(t, stub, "AddClaim", []string{"2", "0", "10", "k1"})
+
+	// Tax is not offset against trade
+	checkQuery(t, stub, "Claims", []string{"2", "tax"}, "[{\"f\":2,\"t\":0,\"v\":10}]")
+	bytes, _ := stub.MockQuery("Claims", []string{"2"})
+	if expected := "[{\"f\":2,\"t\":0,\"v\":5},{\"f\":2,\"t\":1,\"v\":-10}]"; sortedClaims(bytes) != sortedClaims([]byte(expected)) {
+		fmt.Println("Claims of 2", string(bytes), "instead of", expected)
+		t.FailNow()
+	}
+
+	checkInvoke(t, stub, "CloseCycle", []string{})
+	bytes, err := stub.MockInvoke("1", "RunNetting", []string{})
+	report := "{\"algorithm\":\"cycles\",\"claims_before\":4,\"claims_after\":3,\"gross_before\":35,\"gross_after\":20," +
+		"\"steps\":1,\"truncated\":false,\"participants\":[0,1,2]}"
+	if err != nil || string(bytes) != report {
+		fmt.Println("RunNetting returned", string(bytes), err, "instead of", report)
+		t.FailNow()
+	}
+	checkQuery(t, stub, "Settlements", []string{}, "[" +
+		"{\"id\":0,\"payer\":1,\"payee\":0,\"amount\":5,\"currency\":\"USD\",\"value_date\":\"\",\"status\":\"pending\",\"confirmed_by_payer\":false,\"confirmed_by_payee\":false}," +
+		"{\"id\":1,\"payer\":2,\"payee\":1,\"amount\":5,\"currency\":\"USD\",\"value_date\":\"\",\"status\":\"pending\",\"confirmed_by_payer\":false,\"confirmed_by_payee\":false}," +
+		"{\"id\":2,\"payer\":0,\"payee\":2,\"amount\":10,\"currency\":\"USD\",\"value_date\":\"\",\"class\":\"tax\",\"status\":\"pending\",\"confirmed_by_payer\":false,\"confirmed_by_payee\":false}]")
+
+	// Paying the tax clears the tax claim only
+	checkInvoke(t, stub, "ConfirmSettlement", []string{"2", "0"})
+	checkInvoke(t, stub, "ConfirmSettlement", []string{"2", "2"})
+	checkQuery(t, stub, "Claims", []string{"2", "tax"}, "[]")
+	checkQuery(t, stub, "Claims", []string{"2"}, "[{\"f\":2,\"t\":1,\"v\":-5}]")
+
+	// Classes can be added
+	checkInvoke(t, stub, "UpdateConfig", []string{"{\"classes\":[\"trade\",\"tax\",\"margin\"]}"})
+	checkQuery(t, stub, "Claims", []string{"2", "margin"}, "[]")
+}
+
+func TestNettingChaincode_GrossClasses(t *testing.T) {
+	log.Info("\n\nGross classes test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	//calls
+	checkInit(t, stub, []string{"{\"classes\":[\"trade\",\"tax\",\"margin\"]," +
+		"\"offsets\":[[\"trade\",\"trade\"],[\"margin\",\"margin\"],[\"trade\",\"margin\"]]}"})
+	for i := 0; i < 3; i++ {
+		checkInvoke(t, stub, "AddCounterParty", []string{})
+	}
+
+	// Opposite tax claims are both kept, cancelling one takes off it only
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "10", "", "tax"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "0", "4", "", "tax"})
+	checkInvoke(t, stub, "CancelClaim", []string{"1", "0", "1", "tax"})
+	checkInvokeFails(t, stub, "CancelClaim", []string{"1", "0", "5", "tax"})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkQuery(t, stub, "Claims", []string{"0", "tax"}, "[{\"f\":0,\"t\":1,\"v\":10},{\"f\":0,\"t\":1,\"v\":-3}]")
+
+	// The cycle 0 -> 1 -> 2 -> 0 is cancelled across trade and margin, each keeps its claims
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "5"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"2", "0", "10", "", "margin"})
+	checkInvoke(t, stub, "CloseCycle", []string{})
+	checkInvoke(t, stub, "RunNetting", []string{})
+	checkQuery(t, stub, "Graph", []string{"tax"}, "{\"Nodes\":[0,1,2,3],\"Edges\":[{\"f\":0,\"t\":1,\"v\":10},{\"f\":1,\"t\":0,\"v\":3}]}")
+	checkQuery(t, stub, "Graph", []string{"trade"}, "{\"Nodes\":[0,1,2,3],\"Edges\":[{\"f\":1,\"t\":2,\"v\":5}]}")
+	checkQuery(t, stub, "Graph", []string{"margin"}, "{\"Nodes\":[0,1,2,3],\"Edges\":[{\"f\":2,\"t\":0,\"v\":5}]}")
+	checkQuery(t, stub, "Settlements", []string{}, "[" +
+		"{\"id\":0,\"payer\":2,\"payee\":1,\"amount\":5,\"currency\":\"USD\",\"value_date\":\"\",\"status\":\"pending\",\"confirmed_by_payer\":false,\"confirmed_by_payee\":false}," +
+		"{\"id\":1,\"payer\":1,\"payee\":0,\"amount\":10,\"currency\":\"USD\",\"value_date\":\"\",\"class\":\"tax\",\"status\":\"pending\",\"confirmed_by_payer\":false,\"confirmed_by_payee\":false}," +
+		"{\"id\":2,\"payer\":0,\"payee\":1,\"amount\":3,\"currency\":\"USD\",\"value_date\":\"\",\"class\":\"tax\",\"status\":\"pending\",\"confirmed_by_payer\":false,\"confirmed_by_payee\":false}," +
+		"{\"id\":3,\"payer\":0,\"payee\":2,\"amount\":5,\"currency\":\"USD\",\"value_date\":\"\",\"class\":\"margin\",\"status\":\"pending\",\"confirmed_by_payer\":false,\"confirmed_by_payee\":false}]")
+
+	// Paying one tax claim leaves the opposite one
+	checkInvoke(t, stub, "ConfirmSettlement", []string{"1", "0"})
+	checkInvoke(t, stub, "ConfirmSettlement", []string{"1", "1"})
+	checkQuery(t, stub, "Claims", []string{"0", "tax"}, "[{\"f\":0,\"t\":1,\"v\":-3}]")
+	checkQuery(t, stub, "Validate", []string{}, "[]")
+}
+
+func TestOptimize_Rounds(t *testing.T) {
+	log.Info("\n\nMulti-round netting test")
+	table := denseTable(12)
+	cfg := defaultConfig()
+	cfg.MaxCycleLength = 4
+	cfg.CycleBudget = 50
+
+	_, single, _ := optimize(table, cfg, algorithmCycles, nil)
+	if single.Rounds != nil {
+		fmt.Println("Single round netting reported rounds", single.Rounds)
+		t.FailNow()
+	}
+
+	// The budget runs out in every round, so later rounds find more cycles
+	cfg.RoundDelta = 0.001
+	result, report, err := optimize(table, cfg, algorithmCycles, nil)
+	if err != nil || len(report.Rounds) < 2 || report.Rounds[0].Steps != single.Steps {
+		fmt.Println("Multi-round netting returned", report, err)
+		t.FailNow()
+	}
+	steps := 0
+	for i, round := range report.Rounds {
+		steps += round.Steps
+		if round.Round != i+1 || i > 0 && round.MetricL1 >= report.Rounds[i-1].MetricL1 {
+			fmt.Println("Round", round, "does not improve on", report.Rounds)
+			t.FailNow()
+		}
+	}
+	last := report.Rounds[len(report.Rounds)-1]
+	if steps != report.Steps || last.Gross != report.GrossAfter ||
+		last.MetricL1 != cfg.round(getStats(result).MetricL1) {
+		fmt.Println("Rounds", report.Rounds, "do not add up to", *report)
+		t.FailNow()
+	}
+}
+
+func TestNettingChaincode_Threshold(t *testing.T) {
+	log.Info("\n\nNetting threshold test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	//calls
+	checkInit(t, stub, []string{})
+	for i := 0; i < 3; i++ {
+		checkInvoke(t, stub, "AddCounterParty", []string{})
+	}
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"2", "0", "0.5"})
+	checkInvoke(t, stub, "CloseCycle", []string{})
+	checkInvokeFails(t, stub, "RunNetting", []string{"", "", "", "", "-1"})
+
+	// The cycle is worth 0.5 only
+	bytes, err := stub.MockInvoke("1", "RunNetting", []string{"", "", "", "", "1"})
+	report := "{\"algorithm\":\"cycles\",\"claims_before\":3,\"claims_after\":3,\"gross_before\":20.5,\"gross_after\":20.5," +
+		"\"steps\":0,\"truncated\":false,\"participants\":[0,1,2],\"threshold\":1}"
+	if err != nil || string(bytes) != report {
+		fmt.Println("RunNetting returned", string(bytes), err, "instead of", report)
+		t.FailNow()
+	}
+	bytes, _ = stub.MockQuery("Claims", []string{"2"})
+	if expected := "[{\"f\":2,\"t\":0,\"v\":0.5},{\"f\":2,\"t\":1,\"v\":-10}]"; sortedClaims(bytes) != sortedClaims([]byte(expected)) {
+		fmt.Println("Claims of 2", string(bytes), "instead of", expected)
+		t.FailNow()
+	}
+}
+
+func TestNettingChaincode_Savings(t *testing.T) {
+	log.Info("\n\nSavings report test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	//calls
+	checkInit(t, stub, []string{})
+	for i := 0; i < 3; i++ {
+		checkInvoke(t, stub, "AddCounterParty", []string{})
+	}
+	if _, err := stub.MockQuery("Savings", []string{}); err == nil {
+		fmt.Println("Savings query did not fail before netting")
+		t.FailNow()
+	}
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"2", "0", "4"})
+	checkInvoke(t, stub, "CloseCycle", []string{})
+	checkInvoke(t, stub, "RunNetting", []string{})
+
+	report := "{\"cycle\":1,\"algorithm\":\"cycles\",\"gross_before\":24,\"gross_after\":12,\"claims_before\":3,\"claims_after\":2,\"compression\":50,\"parties\":[" +
+		"{\"counter_party\":0,\"obligations_before\":4,\"obligations_after\":0,\"receivables_before\":10,\"receivables_after\":6,\"payments_before\":1,\"payments_after\":0,\"obligations_saved\":4}," +
+		"{\"counter_party\":1,\"obligations_before\":10,\"obligations_after\":6,\"receivables_before\":10,\"receivables_after\":6,\"payments_before\":1,\"payments_after\":1,\"obligations_saved\":4}," +
+		"{\"counter_party\":2,\"obligations_before\":10,\"obligations_after\":6,\"receivables_before\":4,\"receivables_after\":0,\"payments_before\":1,\"payments_after\":1,\"obligations_saved\":4}]}"
+	checkQuery(t, stub, "Savings", []string{}, report)
+	checkQuery(t, stub, "Savings", []string{"1"}, report)
+	if _, err := stub.MockQuery("Savings", []string{"2"}); err == nil {
+		fmt.Println("Savings query did not fail for a cycle not netted")
+		t.FailNow()
+	}
+
+	// Every report has a key of its own, the last one is the default
+	for _, args := range [][]string{{"0", "0"}, {"0", "1"}, {"1", "1"}, {"1", "2"}} {
+		checkInvoke(t, stub, "ConfirmSettlement", args)
+	}
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "3"})
+	checkInvoke(t, stub, "CloseCycle", []string{})
+	checkInvoke(t, stub, "RunNetting", []string{})
+	checkQuery(t, stub, "Savings", []string{"1"}, report)
+	bytes, err := stub.MockQuery("Savings", []string{})
+	if err != nil || !strings.HasPrefix(string(bytes), "{\"cycle\":2,") {
+		fmt.Println("Savings query returned", string(bytes), err, "instead of the report of cycle 2")
+		t.FailNow()
+	}
+	for _, key := range []string{savingsKeyPrefix + "1", savingsKeyPrefix + "2"} {
+		if _, ok := stub.State[key]; !ok {
+			fmt.Println(key, "was not saved")
+			t.FailNow()
+		}
+	}
+	checkInvoke(t, stub, "Clear", []string{})
+	if _, err := stub.MockQuery("Savings", []string{"1"}); err == nil {
+		fmt.Println("Savings query did not fail after Clear")
+		t.FailNow()
+	}
+}
+
+func TestNettingChaincode_Trace(t *testing.T) {
+	log.Info("\n\nObligation trace test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	//calls
+	checkInit(t, stub, []string{})
+	for i := 0; i < 4; i++ {
+		checkInvoke(t, stub, "AddCounterParty", []string{})
+	}
+	if _, err := stub.MockQuery("Trace", []string{"0", "1"}); err == nil {
+		fmt.Println("Trace query did not fail before netting")
+		t.FailNow()
+	}
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"2", "0", "5", "gateway-1"})
+	checkInvoke(t, stub, "CancelClaim", []string{"2", "0", "1"})
+	checkInvoke(t, stub, "CloseCycle", []string{})
+	checkInvoke(t, stub, "RunNetting", []string{})
+
+	// The cycle 0 -> 1 -> 2 -> 0 is cancelled, claims are traced to the submitted ones
+	cancelled := "{\"cycle\":1,\"algorithm\":\"cycles\",\"claim\":{\"f\":0,\"t\":1,\"before\":10,\"after\":6," +
+		"\"submitted\":[{\"f\":0,\"t\":1,\"v\":10,\"cycle\":1}],\"carried\":0}," +
+		"\"contributions\":[{\"counter_parties\":[1,0,2],\"amount\":4,\"cancelled\":true,\"claims\":[" +
+		"{\"f\":0,\"t\":1,\"before\":10,\"after\":6,\"submitted\":[{\"f\":0,\"t\":1,\"v\":10,\"cycle\":1}],\"carried\":0}," +
+		"{\"f\":2,\"t\":0,\"before\":4,\"after\":0,\"submitted\":[" +
+		"{\"f\":2,\"t\":0,\"v\":5,\"key\":\"gateway-1\",\"cycle\":1},{\"f\":2,\"t\":0,\"v\":-1,\"cycle\":1}],\"carried\":0}," +
+		"{\"f\":1,\"t\":2,\"before\":10,\"after\":6,\"submitted\":[{\"f\":1,\"t\":2,\"v\":10,\"cycle\":1}],\"carried\":0}]}]}"
+	checkQuery(t, stub, "Trace", []string{"1", "0"}, cancelled)
+	checkQuery(t, stub, "Trace", []string{"1", "0", "1"}, cancelled)
+	for _, args := range [][]string{{"0"}, {"0", "0"}, {"0", "a"}, {"0", "1", "2"}, {"0", "3"}} {
+		if _, err := stub.MockQuery("Trace", args); err == nil {
+			fmt.Println("Trace query did not fail for", args)
+			t.FailNow()
+		}
+	}
+
+	// Every claim has a key of its own
+	records := 0
+	for key := range stub.State {
+		if strings.HasPrefix(key, claimRecordsKeyPrefix) {
+			records++
+		}
+	}
+	if records != 4 {
+		fmt.Println(records, "claim records instead of 4")
+		t.FailNow()
+	}
+
+	// Runs are deleted with the claims they traced
+	checkInvoke(t, stub, "Clear", []string{})
+	for key := range stub.State {
+		if strings.HasPrefix(key, nettingRunKeyPrefix) || strings.HasPrefix(key, claimRecordsKeyPrefix) {
+			fmt.Println(key, "was kept by Clear")
+			t.FailNow()
+		}
+	}
+
+	// A claim of someone who did not participate is carried over to the next run,
+	// which is the only one kept
+	checkInvoke(t, stub, "UpdateConfig", []string{"{\"runs_kept\":1}"})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddCounterParty", []string{})
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "5"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "3"})
+	checkInvoke(t, stub, "CloseCycle", []string{})
+	checkInvoke(t, stub, "RunNetting", []string{"", "", "", "0,1"})
+	checkInvoke(t, stub, "ConfirmSettlement", []string{"0", "0"})
+	checkInvoke(t, stub, "ConfirmSettlement", []string{"0", "1"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "2"})
+	checkInvoke(t, stub, "CloseCycle", []string{})
+	checkInvoke(t, stub, "RunNetting", []string{})
+	checkQuery(t, stub, "Trace", []string{"1", "2"}, "{\"cycle\":2,\"algorithm\":\"cycles\",\"claim\":{\"f\":1,\"t\":2,\"before\":5,\"after\":5," +
+		"\"submitted\":[{\"f\":1,\"t\":2,\"v\":2,\"cycle\":2}],\"carried\":3},\"contributions\":[]}")
+	if _, err := stub.MockQuery("Trace", []string{"0", "1", "1"}); err == nil {
+		fmt.Println("Trace query did not fail for a run no longer kept")
+		t.FailNow()
+	}
+	for key := range stub.State {
+		if key == nettingRunKeyPrefix+"1" || strings.HasPrefix(key, claimRecordsCycleKey(1)) {
+			fmt.Println(key, "was kept with the run dropped")
+			t.FailNow()
+		}
+	}
+
+	// 0 never traded with 2, but is owed by it once the chain is shortened
+	stub = NewMockCallerStub(scc, "admin")
+	checkInit(t, stub, []string{})
+	for i := 0; i < 3; i++ {
+		checkInvoke(t, stub, "AddCounterParty", []string{})
+	}
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "5"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "5"})
+	checkInvoke(t, stub, "CloseCycle", []string{})
+	checkInvoke(t, stub, "RunNetting", []string{"", "", "paymentcount"})
+	checkQuery(t, stub, "Trace", []string{"2", "0"}, "{\"cycle\":1,\"algorithm\":\"paymentcount\",\"claim\":{\"f\":0,\"t\":2,\"before\":0,\"after\":5," +
+		"\"submitted\":[],\"carried\":0},\"contributions\":[{\"counter_parties\":[0,2,1],\"amount\":5,\"cancelled\":false,\"claims\":[" +
+		"{\"f\":0,\"t\":2,\"before\":0,\"after\":5,\"submitted\":[],\"carried\":0}," +
+		"{\"f\":1,\"t\":2,\"before\":5,\"after\":0,\"submitted\":[{\"f\":1,\"t\":2,\"v\":5,\"cycle\":1}],\"carried\":0}," +
+		"{\"f\":0,\"t\":1,\"before\":5,\"after\":0,\"submitted\":[{\"f\":0,\"t\":1,\"v\":5,\"cycle\":1}],\"carried\":0}]}]}")
+}
+
+func TestNettingChaincode_RiskStats(t *testing.T) {
+	log.Info("\n\nRisk metrics test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	//calls
+	checkInit(t, stub, []string{"{\"classes\":[\"trade\",\"margin\",\"tax\"]," +
+		"\"offsets\":[[\"trade\",\"trade\"],[\"margin\",\"margin\"],[\"trade\",\"margin\"]]}"})
+	for i := 0; i < 4; i++ {
+		checkInvoke(t, stub, "AddCounterParty", []string{})
+	}
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"2", "0", "5", "", "margin"})
+	checkInvoke(t, stub, "AddClaim", []string{"0", "3", "10", "", "margin"})
+	checkInvoke(t, stub, "AddClaim", []string{"3", "0", "1", "", "tax"})
+	for _, top := range []string{"0", "a"} {
+		if _, err := stub.MockQuery("Stats", []string{"", top}); err == nil {
+			fmt.Println("Stats with top", top, "did not fail")
+			t.FailNow()
+		}
+	}
+
+	// Margin is netted with trade, so they are one pool
+	checkQuery(t, stub, "Stats", []string{"margin", "2"}, "{\"number_of_counter_parties\":4,\"number_of_claims\":4," +
+		"\"metric_l1\":5.833333333333333,\"metric_l2\":7.359800721939872,\"sum_of_h\":0,\"risk\":{" +
+		"\"largest_exposure\":{\"f\":0,\"t\":1,\"v\":10}," +
+		"\"top_exposures\":[[{\"f\":0,\"t\":1,\"v\":10},{\"f\":0,\"t\":3,\"v\":10}],[{\"f\":1,\"t\":2,\"v\":10}],[{\"f\":2,\"t\":0,\"v\":5}],[]]," +
+		"\"creditor_hhi\":[0.5,1,1,0],\"debtor_hhi\":[1,1,1,1],\"density\":0.6666666666666666," +
+		"\"components\":1,\"longest_cycle\":3,\"truncated\":false}}")
+	checkQuery(t, stub, "Stats", []string{"tax", "1"}, "{\"number_of_counter_parties\":4,\"number_of_claims\":1," +
+		"\"metric_l1\":0.16666666666666666,\"metric_l2\":0.408248290463863,\"sum_of_h\":0,\"risk\":{" +
+		"\"largest_exposure\":{\"f\":3,\"t\":0,\"v\":1},\"top_exposures\":[[],[],[],[{\"f\":3,\"t\":0,\"v\":1}]]," +
+		"\"creditor_hhi\":[0,0,0,1],\"debtor_hhi\":[1,0,0,0],\"density\":0.16666666666666666," +
+		"\"components\":0,\"longest_cycle\":0,\"truncated\":false}}")
+
+	// Claims get a currency once they are netted, pending instructions in it are what is owed
+	checkInvoke(t, stub, "CloseCycle", []string{})
+	checkInvoke(t, stub, "RunNetting", []string{"USD"})
+	checkQuery(t, stub, "Stats", []string{"margin", "", "USD"},
+		"{\"number_of_counter_parties\":4,\"number_of_claims\":3,\"metric_l1\":3.3333333333333335,\"metric_l2\":5,\"sum_of_h\":0}")
+	checkQuery(t, stub, "Stats", []string{"tax", "1", "USD"}, "{\"number_of_counter_parties\":4,\"number_of_claims\":1," +
+		"\"metric_l1\":0.16666666666666666,\"metric_l2\":0.408248290463863,\"sum_of_h\":0,\"risk\":{" +
+		"\"largest_exposure\":{\"f\":3,\"t\":0,\"v\":1},\"top_exposures\":[[],[],[],[{\"f\":3,\"t\":0,\"v\":1}]]," +
+		"\"creditor_hhi\":[0,0,0,1],\"debtor_hhi\":[1,0,0,0],\"density\":0.16666666666666666," +
+		"\"components\":0,\"longest_cycle\":0,\"truncated\":false}}")
+	checkQuery(t, stub, "Stats", []string{"", "", "CHF"},
+		"{\"number_of_counter_parties\":4,\"number_of_claims\":0,\"metric_l1\":0,\"metric_l2\":0,\"sum_of_h\":0}")
+
+	// Without a budget configured, the search for the longest cycle still has one
+	cfg := defaultConfig()
+	cfg.CycleBudget = 0
+	if metrics := newRiskMetrics(randomTable(40, 0.9, false), 1, cfg); !metrics.Truncated {
+		fmt.Println("Longest cycle", metrics.LongestCycle, "was searched for without a budget")
+		t.FailNow()
+	}
+}
+
+func TestNettingChaincode_ClaimCycles(t *testing.T) {
+	log.Info("\n\nCycle inspection test")
+	scc := new(Chaincode)
+	stub := NewMockCallerStub(scc, "admin")
+	//calls
+	checkInit(t, stub, []string{})
+	for i := 0; i < 4; i++ {
+		checkInvoke(t, stub, "AddCounterParty", []string{})
+	}
+	checkInvoke(t, stub, "AddClaim", []string{"0", "1", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"1", "2", "10"})
+	checkInvoke(t, stub, "AddClaim", []string{"2", "0", "4"})
+	checkInvoke(t, stub, "AddClaim", []string{"2", "3", "3"})
+	checkInvoke(t, stub, "AddClaim", []string{"3", "0", "2"})
+	checkInvoke(t, stub, "AddClaim", []string{"3", "2", "1"})
+	for _, args := range [][]string{{"-1"}, {"", "", "0"}} {
+		if _, err := stub.MockQuery("Cycles", args); err == nil {
+			fmt.Println("Cycles with", args, "did not fail")
+			t.FailNow()
+		}
+	}
+
+	// 3 -> 2 is netted into 2 -> 3 by AddClaim
+	checkQuery(t, stub, "Cycles", []string{}, "{\"cycles\":[" +
+		"{\"counter_parties\":[0,1,2],\"edges\":[{\"f\":0,\"t\":1,\"v\":10},{\"f\":1,\"t\":2,\"v\":10},{\"f\":2,\"t\":0,\"v\":4}],\"min_weight\":4,\"saving\":12}," +
+		"{\"counter_parties\":[0,1,2,3],\"edges\":[{\"f\":0,\"t\":1,\"v\":10},{\"f\":1,\"t\":2,\"v\":10},{\"f\":2,\"t\":3,\"v\":2},{\"f\":3,\"t\":0,\"v\":2}],\"min_weight\":2,\"saving\":8}]," +
+		"\"offset\":0,\"total\":2,\"truncated\":false}")
+	checkQuery(t, stub, "Cycles", []string{"3"}, "{\"cycles\":[" +
+		"{\"counter_parties\":[0,1,2],\"edges\":[{\"f\":0,\"t\":1,\"v\":10},{\"f\":1,\"t\":2,\"v\":10},{\"f\":2,\"t\":0,\"v\":4}],\"min_weight\":4,\"saving\":12}]," +
+		"\"offset\":0,\"total\":1,\"truncated\":false}")
+	checkQuery(t, stub, "Cycles", []string{"", "1", "1"}, "{\"cycles\":[" +
+		"{\"counter_parties\":[0,1,2,3],\"edges\":[{\"f\":0,\"t\":1,\"v\":10},{\"f\":1,\"t\":2,\"v\":10},{\"f\":2,\"t\":3,\"v\":2},{\"f\":3,\"t\":0,\"v\":2}],\"min_weight\":2,\"saving\":8}]," +
+		"\"offset\":1,\"total\":2,\"truncated\":false}")
+
+	// The search stops once the page is full and there is another cycle
+	checkQuery(t, stub, "Cycles", []string{"", "0", "1"}, "{\"cycles\":[" +
+		"{\"counter_parties\":[0,1,2],\"edges\":[{\"f\":0,\"t\":1,\"v\":10},{\"f\":1,\"t\":2,\"v\":10},{\"f\":2,\"t\":0,\"v\":4}],\"min_weight\":4,\"saving\":12}]," +
+		"\"offset\":0,\"total\":1,\"truncated\":true}")
+}
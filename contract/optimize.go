@@ -0,0 +1,218 @@
+package contract
+
+import (
+	"github.com/gonum/graph"
+	"github.com/gonum/graph/simple"
+	"github.com/gonum/graph/topo"
+	"math"
+	"sort"
+)
+
+// Same as NettingTable.Optimize, but cycles longer than maxLength (unless it is 0)
+// are left as they are. CyclesIn enumerates every elementary cycle, which is too many
+// for a few dozen densely connected counter parties, so cycles are searched for within
+// strongly connected components and at most budget edges (unless it is 0) are explored.
+// A search of every cycle takes time exponential in the number of counter parties,
+// so the configuration has a budget by default.
+// The number of cancelled cycles is returned, and whether the budget ran out.
+func cancelCycles(this Table, maxLength int, budget int) (Table, int, bool) {
+	graph := toGraph(this)
+	search := &cycleSearch{graph: graph, maxLength: maxLength, budget: budget}
+	complete := search.run()
+	return fromGraph(this, graph), search.cancelled, !complete
+}
+
+// Elementary cycles of claims up to maxLength, each passed to visit once, starting at its
+// smallest counter party, until visit returns false. The table is not changed, visit has
+// to copy a cycle it keeps. False when the search has stopped before every cycle was found.
+func findCycles(this Table, maxLength int, budget int, visit func(cycle []int) bool) bool {
+	search := &cycleSearch{graph: toGraph(this), maxLength: maxLength, budget: budget, visit: visit}
+	return search.run()
+}
+
+// Depth first search of cycles of claims, each one cancelled as soon as it is found
+// unless there is a visit function
+type cycleSearch struct {
+	graph     *simple.DirectedGraph
+	maxLength int
+	budget    int
+	visit     func(cycle []int) bool
+	steps     int
+	cancelled int
+	// Nodes of the current component, by ID, and claims within it
+	nodes []int
+	next  map[int][]int
+	path  []int
+	// Index of the first claim of the path used up by a cancelled cycle, -1 - none.
+	// The search goes back to the node before it.
+	cut int
+}
+
+// False when the budget has run out or visit has stopped the search
+func (this *cycleSearch) run() bool {
+	// A cycle never leaves its component
+	components := topo.TarjanSCC(this.graph)
+	sort.Sort(componentsByID(components))
+	for _, component := range components {
+		if len(component) < 2 {
+			continue
+		}
+		this.setComponent(component)
+		// Every cycle is found once, from its smallest node
+		for _, start := range this.nodes {
+			this.path = append(this.path[:0], start)
+			this.cut = -1
+			if !this.from(start) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (this *cycleSearch) setComponent(component []graph.Node) {
+	inComponent := map[int]bool{}
+	this.nodes = this.nodes[:0]
+	for _, node := range component {
+		inComponent[node.ID()] = true
+		this.nodes = append(this.nodes, node.ID())
+	}
+	sort.Ints(this.nodes)
+
+	this.next = map[int][]int{}
+	for _, id := range this.nodes {
+		for _, to := range this.graph.From(simple.Node(id)) {
+			if inComponent[to.ID()] {
+				this.next[id] = append(this.next[id], to.ID())
+			}
+		}
+		sort.Ints(this.next[id])
+	}
+}
+
+func (this *cycleSearch) weight(from, to int) float64 {
+	return this.graph.Edge(simple.Node(from), simple.Node(to)).Weight()
+}
+
+// Extends the path ending at node, false when the search stops
+func (this *cycleSearch) from(node int) bool {
+	start := this.path[0]
+	for _, to := range this.next[node] {
+		// A cycle cancelled here or deeper may have used up a claim of the path
+		if this.cut >= 0 {
+			if this.cut < len(this.path)-1 {
+				return true
+			}
+			this.cut = -1
+		}
+		if this.weight(node, to) <= 0.0 {
+			continue
+		}
+		this.steps++
+		if this.budget > 0 && this.steps > this.budget {
+			return false
+		}
+
+		if to == start {
+			if this.visit != nil {
+				if !this.visit(this.path) {
+					return false
+				}
+			} else {
+				this.cancel()
+			}
+			continue
+		}
+		if to < start || this.onPath(to) {
+			continue
+		}
+		if this.maxLength > 0 && len(this.path) >= this.maxLength {
+			continue
+		}
+		this.path = append(this.path, to)
+		ok := this.from(to)
+		this.path = this.path[:len(this.path)-1]
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (this *cycleSearch) onPath(node int) bool {
+	for _, id := range this.path {
+		if id == node {
+			return true
+		}
+	}
+	return false
+}
+
+// Subtracts the smallest claim of the path closed back to its start
+func (this *cycleSearch) cancel() {
+	cycle := this.path
+	next := func(i int) int { return cycle[(i+1)%len(cycle)] }
+
+	// find min weight in cycle
+	minWeight := math.MaxFloat64
+	for i := range cycle {
+		weight := this.weight(cycle[i], next(i))
+		if weight < minWeight {
+			minWeight = weight
+		}
+	}
+	if minWeight <= 0.0 {
+		return
+	}
+
+	// subtract, the claim closing the cycle is not on the path
+	for i := range cycle {
+		weight := this.weight(cycle[i], next(i)) - minWeight
+		this.graph.SetEdge(simple.Edge{F: simple.Node(cycle[i]), T: simple.Node(next(i)), W: weight})
+		if weight <= 0.0 && i < len(cycle)-1 && this.cut < 0 {
+			this.cut = i
+		}
+	}
+	this.cancelled++
+}
+
+// Components in the order of their smallest nodes, so results do not depend on map order
+type componentsByID [][]graph.Node
+
+func (this componentsByID) Len() int      { return len(this) }
+func (this componentsByID) Swap(i, j int) { this[i], this[j] = this[j], this[i] }
+func (this componentsByID) Less(i, j int) bool {
+	return minID(this[i]) < minID(this[j])
+}
+
+func minID(nodes []graph.Node) int {
+	result := nodes[0].ID()
+	for _, node := range nodes {
+		if node.ID() < result {
+			result = node.ID()
+		}
+	}
+	return result
+}
+
+func toGraph(this Table) *simple.DirectedGraph {
+	graph := simple.NewDirectedGraph(0, 0)
+	for i := counterParties(this) - 1; i >= 0; i-- {
+		graph.AddNode(simple.Node(i))
+	}
+	for _, c := range getAllClaims(this) {
+		graph.SetEdge(simple.Edge{F: simple.Node(c.From), T: simple.Node(c.To), W: c.Value})
+	}
+	return graph
+}
+
+// Edges of zero weight are dropped
+func fromGraph(this Table, graph *simple.DirectedGraph) Table {
+	result := emptyCopy(this)
+	for _, edge := range graph.Edges() {
+		if edge.Weight() > 0.0 {
+			result.AddClaim(edge.From().ID(), edge.To().ID(), edge.Weight())
+		}
+	}
+	return result
+}
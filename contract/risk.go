@@ -0,0 +1,106 @@
+package contract
+
+import (
+	"github.com/VladimirStarostenkov/netting"
+	"github.com/gonum/graph/topo"
+	"sort"
+)
+
+// Returned by the Stats query, risk metrics only when asked for
+type riskStats struct {
+	netting.NettingTableStats
+	Risk *riskMetrics `json:"risk,omitempty"`
+}
+
+// A claim of "f" on "t" is an exposure of "f" to "t"
+type riskMetrics struct {
+	// nil without claims
+	LargestExposure *claim `json:"largest_exposure"`
+	// The largest claims of every counter party, largest first
+	TopExposures [][]claim `json:"top_exposures"`
+	// Herfindahl index of the claims of every counter party over its debtors, 0 - no claims
+	CreditorHHI []float64 `json:"creditor_hhi"`
+	// Herfindahl index of the claims on every counter party over its creditors, 0 - no claims
+	DebtorHHI []float64 `json:"debtor_hhi"`
+	// Share of the pairs of counter parties with a claim between them
+	Density float64 `json:"density"`
+	// Strongly connected components of at least 2 counter parties, where cycles are
+	Components   int `json:"components"`
+	LongestCycle int `json:"longest_cycle"`
+	// The cycle budget ran out, longer cycles may exist
+	Truncated bool `json:"truncated"`
+}
+
+// Largest first, then in the order of counter parties
+type claimsByValue []claim
+
+func (c claimsByValue) Len() int      { return len(c) }
+func (c claimsByValue) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c claimsByValue) Less(i, j int) bool {
+	if c[i].Value != c[j].Value {
+		return c[i].Value > c[j].Value
+	}
+	return claimsByCounterParties(c).Less(i, j)
+}
+
+// Claims of the payees of pending instructions in the currency on their payers,
+// of the classes of the pool, added to the table
+func pendingTable(this Table, instructions []settlementInstruction, currency string, pool []int, cfg *config) Table {
+	inPool := map[string]bool{}
+	for _, class := range pool {
+		inPool[cfg.classLabel(class)] = true
+	}
+	for _, instruction := range instructions {
+		if instruction.Status == settlementPending && instruction.Currency == currency && inPool[instruction.Class] {
+			this.AddClaim(instruction.Payee, instruction.Payer, instruction.Amount)
+		}
+	}
+	return this
+}
+
+// Top is the number of the largest claims listed for every counter party
+func newRiskMetrics(this Table, top int, cfg *config) *riskMetrics {
+	N := counterParties(this)
+	claims := getAllClaims(this)
+	sort.Sort(claimsByValue(claims))
+
+	metrics := &riskMetrics{TopExposures: make([][]claim, N)}
+	if len(claims) > 0 {
+		metrics.LargestExposure = &claims[0]
+	}
+	for id := range metrics.TopExposures {
+		metrics.TopExposures[id] = []claim{}
+	}
+	owed, owes := make([]float64, N), make([]float64, N)
+	for _, c := range claims {
+		if len(metrics.TopExposures[c.From]) < top {
+			metrics.TopExposures[c.From] = append(metrics.TopExposures[c.From], c)
+		}
+		owed[c.From] += c.Value
+		owes[c.To] += c.Value
+	}
+
+	// Sums of squared shares
+	metrics.CreditorHHI, metrics.DebtorHHI = make([]float64, N), make([]float64, N)
+	for _, c := range claims {
+		metrics.CreditorHHI[c.From] += (c.Value / owed[c.From]) * (c.Value / owed[c.From])
+		metrics.DebtorHHI[c.To] += (c.Value / owes[c.To]) * (c.Value / owes[c.To])
+	}
+
+	if N > 1 {
+		metrics.Density = float64(len(claims)) / (float64(N) * float64(N-1) / 2.0)
+	}
+	for _, component := range topo.TarjanSCC(toGraph(this)) {
+		if len(component) > 1 {
+			metrics.Components++
+		}
+	}
+	metrics.Truncated = !findCycles(this, 0, cfg.queryCycleBudget(), func(cycle []int) bool {
+		if len(cycle) > metrics.LongestCycle {
+			metrics.LongestCycle = len(cycle)
+		}
+		return true
+	})
+
+	return metrics
+}
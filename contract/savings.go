@@ -0,0 +1,150 @@
+package contract
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Reports are kept under this prefix and the cycle netted, one key per netting run
+const savingsKeyPrefix string = "Savings."
+
+// What a netting run has saved, over the claims of every class of the netted cycle
+type savingsReport struct {
+	Cycle        int     `json:"cycle"`
+	Algorithm    string  `json:"algorithm"`
+	GrossBefore  float64 `json:"gross_before"`
+	GrossAfter   float64 `json:"gross_after"`
+	ClaimsBefore int     `json:"claims_before"`
+	ClaimsAfter  int     `json:"claims_after"`
+	// Share of the gross obligations eliminated, in percent
+	Compression float64        `json:"compression"`
+	Parties     []partySavings `json:"parties"`
+}
+
+// Obligations of a counter party are what it has to pay, receivables what it is paid
+type partySavings struct {
+	CounterParty      int     `json:"counter_party"`
+	ObligationsBefore float64 `json:"obligations_before"`
+	ObligationsAfter  float64 `json:"obligations_after"`
+	ReceivablesBefore float64 `json:"receivables_before"`
+	ReceivablesAfter  float64 `json:"receivables_after"`
+	PaymentsBefore    int     `json:"payments_before"`
+	PaymentsAfter     int     `json:"payments_after"`
+	ObligationsSaved  float64 `json:"obligations_saved"`
+}
+
+// args: [Cycle int], the last netted one by default
+func (this smartContract) query_Savings(store Store, args []string) ([]byte, error) {
+	log.Debugf("querySavings called with args: %s\n", args)
+
+	// Load existing data, the last run is always kept
+	cycle := 0
+	if len(args) > 0 && args[0] != "" {
+		var err error
+		cycle, err = strconv.Atoi(args[0])
+		if err != nil {
+			log.Errorf("strconv.Atoi(args[0]) error: %s", err.Error())
+			return nil, err
+		}
+	} else {
+		runs, err := loadNettingRuns(store)
+		checkCriticalError(err)
+		if len(runs) == 0 {
+			message := "no savings report, no cycle was netted yet\n"
+			log.Errorf(message)
+			return nil, errors.New(message)
+		}
+		cycle = runs[len(runs)-1]
+	}
+	found, err := loadSavings(cycle, store)
+	checkCriticalError(err)
+	if found == nil {
+		message := fmt.Sprintf("no savings report for cycle %d, it was not netted\n", cycle)
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+
+	bts, err := json.Marshal(found)
+	if err != nil {
+		log.Errorf("json.Marshal(report) error: %s", err.Error())
+		return nil, err
+	}
+
+	return bts, nil
+}
+
+// Compares the tables of every class before and after netting
+func newSavingsReport(cycle int, algorithm string, before []Table, after []Table, cfg *config) savingsReport {
+	N := counterParties(before[0])
+	count := func(tables []Table) (gross float64, claims int, obligations []float64, receivables []float64, payments []int) {
+		obligations, receivables, payments = make([]float64, N), make([]float64, N), make([]int, N)
+		for _, table := range tables {
+			for _, c := range getAllClaims(table) {
+				// "t" pays "f"
+				gross += c.Value
+				claims++
+				obligations[c.To] += c.Value
+				payments[c.To]++
+				receivables[c.From] += c.Value
+			}
+		}
+		return
+	}
+	grossBefore, claimsBefore, obligationsBefore, receivablesBefore, paymentsBefore := count(before)
+	grossAfter, claimsAfter, obligationsAfter, receivablesAfter, paymentsAfter := count(after)
+
+	report := savingsReport{Cycle: cycle, Algorithm: algorithm,
+		GrossBefore: cfg.round(grossBefore), GrossAfter: cfg.round(grossAfter),
+		ClaimsBefore: claimsBefore, ClaimsAfter: claimsAfter, Parties: make([]partySavings, N)}
+	if grossBefore > 0.0 {
+		report.Compression = cfg.round(100.0 * (grossBefore - grossAfter) / grossBefore)
+	}
+	for id := range report.Parties {
+		report.Parties[id] = partySavings{
+			CounterParty:      id,
+			ObligationsBefore: cfg.round(obligationsBefore[id]),
+			ObligationsAfter:  cfg.round(obligationsAfter[id]),
+			ReceivablesBefore: cfg.round(receivablesBefore[id]),
+			ReceivablesAfter:  cfg.round(receivablesAfter[id]),
+			PaymentsBefore:    paymentsBefore[id],
+			PaymentsAfter:     paymentsAfter[id],
+			ObligationsSaved:  cfg.round(obligationsBefore[id] - obligationsAfter[id]),
+		}
+	}
+	return report
+}
+
+func saveSavings(report *savingsReport, store Store) error {
+	bytes, err := json.Marshal(report)
+	if err != nil {
+		log.Errorf("json.Marshal(report) error: %s", err.Error())
+		return err
+	}
+	err = store.PutState(savingsKeyPrefix+strconv.Itoa(report.Cycle), bytes)
+	if err != nil {
+		log.Errorf("store.PutState(savingsKeyPrefix+cycle, bytes) error: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// nil if the cycle was not netted
+func loadSavings(cycle int, store Store) (*savingsReport, error) {
+	bytes, err := store.GetState(savingsKeyPrefix + strconv.Itoa(cycle))
+	if err != nil {
+		log.Errorf("store.GetState(savingsKeyPrefix+cycle) error: %s", err.Error())
+		return nil, err
+	}
+	if len(bytes) == 0 {
+		return nil, nil
+	}
+	var report savingsReport
+	err = json.Unmarshal(bytes, &report)
+	if err != nil {
+		log.Errorf("json.Unmarshal(bytes, &report) error: %s", err.Error())
+		return nil, err
+	}
+	return &report, nil
+}
@@ -0,0 +1,290 @@
+package contract
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A scenario is replayed against the chaincode in a MockStub, one step a line:
+//
+//	# comment
+//	init [Config]                        Init
+//	parties N                            AddCounterParty N times
+//	claim From To Value [Key [Class]]    AddClaim
+//	matrix                               claims of the rows that follow, as example01.txt
+//	                                     of the netting library: a positive value of row i,
+//	                                     column j is a claim of i on j, negative ones are
+//	                                     the same claims seen by j
+//	close                                CloseCycle
+//	net [args]                           RunNetting
+//	invoke Function [args]               any invoke
+//	fails Function [args]                an invoke that has to fail
+//	expect Query [args] = json           the query result, formatting aside
+//	expect-any-order Query [args] = json same, elements of arrays in any order
+//
+// Arguments are separated by spaces, "" is an empty one.
+type Scenario struct {
+	Name  string
+	steps []scenarioStep
+}
+
+type scenarioStep struct {
+	line     int
+	function string
+	args     []string
+	// Only for expect
+	query    bool
+	anyOrder bool
+	expected string
+	// An invoke has to fail
+	fails bool
+}
+
+// A step of a scenario that did not go as expected
+type Mismatch struct {
+	Scenario string
+	Line     int
+	Function string
+	Expected string
+	Actual   string
+}
+
+func (this Mismatch) String() string {
+	return fmt.Sprintf("%s:%d: %s returned %s, expected %s", this.Scenario, this.Line, this.Function, this.Actual, this.Expected)
+}
+
+func ParseScenario(name string, r io.Reader) (*Scenario, error) {
+	this := &Scenario{Name: name}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	// Reads the next line that is not blank or a comment, false at the end
+	next := func() (string, bool) {
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text != "" && !strings.HasPrefix(text, "#") {
+				return text, true
+			}
+		}
+		return "", false
+	}
+	fail := func(format string, args ...interface{}) error {
+		message := fmt.Sprintf("%s:%d: %s\n", name, line, fmt.Sprintf(format, args...))
+		log.Errorf(message)
+		return errors.New(message)
+	}
+
+	for text, ok := next(); ok; text, ok = next() {
+		expected := ""
+		fields := strings.Fields(text)
+		if strings.HasPrefix(fields[0], "expect") {
+			parts := strings.SplitN(text, " = ", 2)
+			if len(parts) < 2 {
+				return nil, fail("no \" = \" in %s", fields[0])
+			}
+			fields, expected = strings.Fields(parts[0]), strings.TrimSpace(parts[1])
+		}
+		args := []string{}
+		for _, field := range fields[1:] {
+			if field == "\"\"" {
+				field = ""
+			}
+			args = append(args, field)
+		}
+		step := scenarioStep{line: line, args: args}
+
+		switch fields[0] {
+		case "init", "claim", "close", "net":
+			step.function = map[string]string{"init": "init", "claim": "AddClaim", "close": "CloseCycle", "net": "RunNetting"}[fields[0]]
+		case "parties":
+			n, err := strconv.Atoi(strings.Join(args, " "))
+			if err != nil || n < 0 {
+				return nil, fail("parties takes a number, not %s", args)
+			}
+			for i := 0; i < n; i++ {
+				this.steps = append(this.steps, scenarioStep{line: line, function: "AddCounterParty", args: []string{}})
+			}
+			continue
+		case "matrix":
+			start := line
+			steps, err := parseMatrix(next, fail)
+			if err != nil {
+				return nil, err
+			}
+			for i := range steps {
+				steps[i].line = start
+			}
+			this.steps = append(this.steps, steps...)
+			continue
+		case "invoke", "fails", "expect", "expect-any-order":
+			if len(args) == 0 {
+				return nil, fail("%s takes a function", fields[0])
+			}
+			step.function, step.args = args[0], args[1:]
+			step.fails = fields[0] == "fails"
+			step.query = strings.HasPrefix(fields[0], "expect")
+			step.anyOrder = fields[0] == "expect-any-order"
+			if step.query {
+				if _, ok := queries[step.function]; !ok {
+					return nil, fail("there is no query %s", step.function)
+				}
+				var err error
+				if step.expected, err = canonicalJSON([]byte(expected), step.anyOrder); err != nil {
+					return nil, fail("expected %s is not JSON: %s", expected, err.Error())
+				}
+			}
+		default:
+			return nil, fail("unknown step %s", fields[0])
+		}
+		if !step.query && step.function != "init" {
+			if _, ok := invokes[step.function]; !ok {
+				return nil, fail("there is no invoke %s", step.function)
+			}
+		}
+		this.steps = append(this.steps, step)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Errorf("scanner.Err() error: %s", err.Error())
+		return nil, err
+	}
+	return this, nil
+}
+
+// The number of values in the first row is the number of rows
+func parseMatrix(next func() (string, bool), fail func(string, ...interface{}) error) ([]scenarioStep, error) {
+	steps := []scenarioStep{}
+	N := -1
+	for i := 0; i != N; i++ {
+		text, ok := next()
+		if !ok {
+			return nil, fail("matrix has %d rows of %d", i, N)
+		}
+		row := strings.Fields(text)
+		if N < 0 {
+			N = len(row)
+		}
+		if len(row) != N {
+			return nil, fail("matrix row of %d values instead of %d", len(row), N)
+		}
+		for j, field := range row {
+			value, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fail("%s is not a number", field)
+			}
+			if value > 0.0 {
+				steps = append(steps, scenarioStep{function: "AddClaim", args: []string{strconv.Itoa(i), strconv.Itoa(j), field}})
+			}
+		}
+	}
+	return steps, nil
+}
+
+// Runs every step on a new chaincode, going on after a mismatch.
+// Like on the ledger, an invoke that fails changes nothing. Every step has the same caller,
+// the admin, which so is the identity of every counter party too.
+func (this *Scenario) Replay() []Mismatch {
+	mismatches := []Mismatch{}
+	stub := NewMockCallerStub(new(Chaincode), "admin")
+	for _, step := range this.steps {
+		mismatch := Mismatch{Scenario: this.Name, Line: step.line, Function: step.function}
+
+		if step.query {
+			result, err := stub.MockQuery(step.function, step.args)
+			actual := ""
+			if err != nil {
+				actual = "error " + strings.TrimSpace(err.Error())
+			} else if actual, err = canonicalJSON(result, step.anyOrder); err != nil {
+				actual = string(result)
+			}
+			if actual != step.expected {
+				mismatch.Expected, mismatch.Actual = step.expected, actual
+				mismatches = append(mismatches, mismatch)
+			}
+			continue
+		}
+
+		before := map[string][]byte{}
+		for key, value := range stub.State {
+			before[key] = value
+		}
+		var err error
+		if step.function == "init" {
+			_, err = stub.MockInit("scenario", step.function, step.args)
+		} else {
+			_, err = stub.MockInvoke("scenario", step.function, step.args)
+		}
+		if err != nil {
+			rollback(stub.MockStub, before)
+		}
+		switch {
+		case err != nil && !step.fails:
+			mismatch.Expected, mismatch.Actual = "success", "error "+strings.TrimSpace(err.Error())
+			mismatches = append(mismatches, mismatch)
+		case err == nil && step.fails:
+			mismatch.Expected, mismatch.Actual = "an error", "success"
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+	return mismatches
+}
+
+// A MockStub keeps what a failed invoke has changed
+func rollback(stub *shim.MockStub, state map[string][]byte) {
+	stub.MockTransactionStart("rollback")
+	for key := range stub.State {
+		if _, ok := state[key]; !ok {
+			stub.DelState(key)
+		}
+	}
+	for key, value := range state {
+		stub.PutState(key, value)
+	}
+	stub.MockTransactionEnd("rollback")
+}
+
+// Same JSON values give the same string, arrays are sorted if their order does not matter
+func canonicalJSON(bytes []byte, anyOrder bool) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal(bytes, &value); err != nil {
+		return "", err
+	}
+	if anyOrder {
+		value = sortArrays(value)
+	}
+	result, err := json.Marshal(value)
+	if err != nil {
+		log.Errorf("json.Marshal(value) error: %s", err.Error())
+		return "", err
+	}
+	return string(result), nil
+}
+
+func sortArrays(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = sortArrays(v[key])
+		}
+	case []interface{}:
+		keys := make([]string, len(v))
+		byKey := map[string]interface{}{}
+		for i := range v {
+			v[i] = sortArrays(v[i])
+			bytes, _ := json.Marshal(v[i])
+			keys[i] = string(bytes)
+			byKey[keys[i]] = v[i]
+		}
+		sort.Strings(keys)
+		for i, key := range keys {
+			v[i] = byKey[key]
+		}
+	}
+	return value
+}
@@ -0,0 +1,87 @@
+package contract
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Golden scenarios, see Scenario for the format
+func TestScenarios(t *testing.T) {
+	log.Info("\n\nGolden scenarios test")
+	paths, _ := filepath.Glob(filepath.Join("testdata", "*.txt"))
+	if len(paths) == 0 {
+		fmt.Println("No scenarios in testdata")
+		t.FailNow()
+	}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Println("Scenario", path, "failed to open", err)
+			t.FailNow()
+		}
+		scenario, err := ParseScenario(filepath.Base(path), file)
+		file.Close()
+		if err != nil {
+			fmt.Println("Scenario", path, "failed to parse", err)
+			t.FailNow()
+		}
+		for _, mismatch := range scenario.Replay() {
+			fmt.Println(mismatch)
+			t.Fail()
+		}
+	}
+}
+
+func TestScenario_Mismatches(t *testing.T) {
+	log.Info("\n\nScenario mismatches test")
+	scenario, err := ParseScenario("mismatches", strings.NewReader(`
+		init
+		parties 2
+		claim 0 1 10
+		claim 0 1 ten
+		fails AddClaim 1 0 5
+		expect-any-order Claims 0 = [ {"f": 0, "t": 1, "v": 5} ]
+		expect Claims 1 = [{"f":1,"t":0,"v":5}]
+		expect Savings = {}
+	`))
+	if err != nil {
+		fmt.Println("Scenario failed to parse", err)
+		t.FailNow()
+	}
+	mismatches := scenario.Replay()
+	lines := []int{}
+	for _, mismatch := range mismatches {
+		lines = append(lines, mismatch.Line)
+	}
+	if fmt.Sprint(lines) != "[5 6 8 9]" {
+		fmt.Println("Mismatches", mismatches, "were not at lines 5, 6, 8 and 9 as expected")
+		t.FailNow()
+	}
+	if mismatches[2].String() != "mismatches:8: Claims returned [{\"f\":1,\"t\":0,\"v\":-5}], expected [{\"f\":1,\"t\":0,\"v\":5}]" {
+		fmt.Println("Mismatch", mismatches[2], "was not as expected")
+		t.FailNow()
+	}
+}
+
+func TestScenario_ParseErrors(t *testing.T) {
+	log.Info("\n\nScenario parse errors test")
+	for _, text := range []string{
+		"init\nunknown 1",
+		"init\nparties a",
+		"init\nexpect Stats",
+		"init\nexpect Stats = {",
+		"init\nexpect AddClaim = {}",
+		"init\ninvoke Stats",
+		"init\nmatrix\n0 1\n-1",
+		"init\nmatrix\n0 1",
+		"init\nmatrix\n0 a\n0 0",
+	} {
+		if _, err := ParseScenario("errors", strings.NewReader(text)); err == nil {
+			fmt.Println("Scenario", text, "did not fail to parse")
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package contract
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Version of the state this chaincode reads and writes
+//   0 - a bare netting table in NettingTable, nothing else
+//   1 - bare netting tables, claims of the open cycle in OpenCycle
+//   2 - netting tables in envelopes, the version kept in SchemaVersion
+const schemaVersion int = 2
+
+const schemaVersionKey string = "SchemaVersion"
+
+// Stored netting tables are wrapped in it, so that their format may change
+type envelope struct {
+	Version int             `json:"version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Migrations from each older version to the next one
+var migrations []func(store Store) error = []func(store Store) error{
+	migrateFrom0,
+	migrateFrom1,
+}
+
+// Reported by Migrate
+type migrationReport struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// Invokes migrate the state before they run anyway, this one only migrates.
+// args: -
+func (this smartContract) invoke_Migrate(store Store, args []string) ([]byte, error) {
+	log.Debugf("invokeMigrate called with args: %s\n", args)
+
+	from, err := migrate(store)
+	if err != nil {
+		return nil, err
+	}
+
+	bts, err := json.Marshal(migrationReport{From: from, To: schemaVersion})
+	if err != nil {
+		log.Errorf("json.Marshal(report) error: %s", err.Error())
+		return nil, err
+	}
+
+	return bts, nil
+}
+
+// Brings the state to the current version, the version it had is returned.
+// A state which was never initialised is left as it is.
+func migrate(store Store) (int, error) {
+	version, err := loadSchemaVersion(store)
+	if err != nil {
+		return 0, err
+	}
+	if version > schemaVersion {
+		message := fmt.Sprintf("state version %d is newer than %d of this chaincode\n", version, schemaVersion)
+		log.Errorf(message)
+		return version, errors.New(message)
+	}
+
+	for v := version; v < schemaVersion; v++ {
+		log.Infof("Migrating state from version %d to %d\n", v, v+1)
+		if err := migrations[v](store); err != nil {
+			return version, err
+		}
+	}
+	if version < schemaVersion {
+		if err := saveSchemaVersion(schemaVersion, store); err != nil {
+			return version, err
+		}
+	}
+	return version, nil
+}
+
+// Run on the state as it is: Migrate reports what it has done,
+// the others have to work on state too broken to be migrated
+var unmigrated map[string]bool = map[string]bool{
+	"Migrate":  true,
+	"Repair":   true,
+	"Validate": true,
+}
+
+// Every other invoke runs on the migrated state
+func migrateForInvoke(function string, store Store) error {
+	if unmigrated[function] {
+		return nil
+	}
+	_, err := migrate(store)
+	return err
+}
+
+// Queries cannot change the state, they see it migrated on top of it
+func migratedView(function string, store Store) (Store, error) {
+	if unmigrated[function] {
+		return store, nil
+	}
+	view := newTxStore(store)
+	if _, err := migrate(view); err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+// Open cycles came with version 1, before that every claim was netted by RunNetting
+func migrateFrom0(store Store) error {
+	nettingTable, err := loadTable(storeKey, store)
+	if err != nil {
+		return err
+	}
+	if err := putBareTable(emptyCopy(nettingTable), openCycleKey, store); err != nil {
+		return err
+	}
+	return saveCycle(&cycleState{Open: 1}, store)
+}
+
+// Tables get wrapped in envelopes
+func migrateFrom1(store Store) error {
+	for _, key := range []string{storeKey, openCycleKey} {
+		nettingTable, err := loadTable(key, store)
+		if err != nil {
+			return err
+		}
+		if err := saveTable(nettingTable, key, store); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Without the key the version is guessed from what is there
+func loadSchemaVersion(store Store) (int, error) {
+	bytes, err := store.GetState(schemaVersionKey)
+	if err != nil {
+		log.Errorf("store.GetState(schemaVersionKey) error: %s", err.Error())
+		return 0, err
+	}
+	if len(bytes) > 0 {
+		version, err := strconv.Atoi(string(bytes))
+		if err != nil {
+			log.Errorf("strconv.Atoi(version) error: %s", err.Error())
+			return 0, err
+		}
+		return version, nil
+	}
+
+	has := func(key string) (bool, error) {
+		bytes, err := store.GetState(key)
+		if err != nil {
+			log.Errorf("store.GetState(key) error: %s", err.Error())
+		}
+		return len(bytes) > 0, err
+	}
+	initialised, err := has(storeKey)
+	if err != nil || !initialised {
+		// Nothing to migrate, Init writes the current version
+		return schemaVersion, err
+	}
+	hasOpenCycle, err := has(openCycleKey)
+	if err != nil || !hasOpenCycle {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func saveSchemaVersion(version int, store Store) error {
+	err := store.PutState(schemaVersionKey, []byte(strconv.Itoa(version)))
+	if err != nil {
+		log.Errorf("store.PutState(schemaVersionKey, version) error: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+func wrapTable(this Table) ([]byte, error) {
+	bytes, err := tableToBytes(this)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{Version: schemaVersion, Data: bytes})
+}
+
+// Same format as NettingTable.ToBytes, but that one lists nodes and edges in the order
+// of its maps, which is different on every peer. Counter parties are 0..N-1.
+func tableToBytes(this Table) ([]byte, error) {
+	nodes := make([]int, counterParties(this))
+	for i := range nodes {
+		nodes[i] = i
+	}
+	bytes, err := json.Marshal(tableBytes{Nodes: nodes, Edges: getAllClaims(this)})
+	if err != nil {
+		log.Errorf("json.Marshal(tableBytes) error: %s", err.Error())
+		return nil, err
+	}
+	return bytes, nil
+}
+
+// Bare tables of versions 0 and 1 are read too
+func unwrapTable(bytes []byte) (*tableBytes, error) {
+	var e envelope
+	err := json.Unmarshal(bytes, &e)
+	if err != nil {
+		log.Errorf("json.Unmarshal(bytes, &envelope) error: %s", err.Error())
+		return nil, err
+	}
+	if e.Version > schemaVersion {
+		message := fmt.Sprintf("table version %d is newer than %d of this chaincode\n", e.Version, schemaVersion)
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	if e.Version > 0 {
+		bytes = e.Data
+	}
+
+	var result tableBytes
+	err = json.Unmarshal(bytes, &result)
+	if err != nil {
+		log.Errorf("json.Unmarshal(bytes, &table) error: %s", err.Error())
+		return nil, err
+	}
+	return &result, nil
+}
+
+// As versions 0 and 1 kept them
+func putBareTable(this Table, key string, store Store) error {
+	bytes, err := tableToBytes(this)
+	if err != nil {
+		return err
+	}
+	err = store.PutState(key, bytes)
+	if err != nil {
+		log.Errorf("store.PutState(key, bytes) error: %s", err.Error())
+		return err
+	}
+	return nil
+}
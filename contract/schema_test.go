@@ -0,0 +1,260 @@
+package contract
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// State as each version of the chaincode left it, 3 counter parties,
+// 0 claims 10 from 1 and 1 claims 10 from 2
+var schemaFixtures = []map[string]string{
+	// 0 - before cycles, only the table
+	{
+		"NettingTable": "{\"Nodes\":[0,1,2],\"Edges\":[{\"f\":0,\"t\":1,\"v\":10},{\"f\":1,\"t\":2,\"v\":10}]}",
+	},
+	// 1 - tables without envelopes, the second claim is in the open cycle
+	{
+		"NettingTable": "{\"Nodes\":[0,1,2],\"Edges\":[{\"f\":0,\"t\":1,\"v\":10}]}",
+		"OpenCycle":    "{\"Nodes\":[0,1,2],\"Edges\":[{\"f\":1,\"t\":2,\"v\":10}]}",
+		"Cycle":        "{\"open\":2,\"frozen\":1,\"cut_off\":\"\",\"netted\":true}",
+		"Settlements":  "[]",
+		"Config":       "{\"algorithm\":\"cycles\",\"precision\":2,\"zero_tolerance\":0,\"max_counter_parties\":0,\"max_cycle_length\":0,\"currencies\":[]}",
+		"Admin":        "",
+	},
+}
+
+func fixtureStore(fixture map[string]string) *MemoryStore {
+	store := NewMemoryStore()
+	for key, value := range fixture {
+		store.PutState(key, []byte(value))
+	}
+	return store
+}
+
+func TestSchema_Migrate(t *testing.T) {
+	log.Info("\n\nSchema migration test")
+	for version, fixture := range schemaFixtures {
+		store := fixtureStore(fixture)
+		service := NewService(store)
+
+		// Queries see the state migrated, but leave it as it is
+		checkServiceQuery(t, service, "Stats", []string{},
+			"{\"number_of_counter_parties\":3,\"number_of_claims\":2,\"metric_l1\":6.666666666666667,\"metric_l2\":8.16496580927726,\"sum_of_h\":0}")
+		if bytes, _ := store.GetState(schemaVersionKey); bytes != nil {
+			fmt.Println("Query of version", version, "changed the state")
+			t.FailNow()
+		}
+
+		bytes, err := service.Invoke("Migrate", []string{})
+		report := fmt.Sprintf("{\"from\":%d,\"to\":%d}", version, schemaVersion)
+		if err != nil || string(bytes) != report {
+			fmt.Println("Migrate of version", version, "returned", string(bytes), err, "instead of", report)
+			t.FailNow()
+		}
+		for _, key := range []string{storeKey, openCycleKey} {
+			if bytes, _ := store.GetState(key); !strings.HasPrefix(string(bytes), "{\"version\":2,\"data\":") {
+				fmt.Println("Table", key, "of version", version, "was migrated to", string(bytes))
+				t.FailNow()
+			}
+		}
+
+		// Once more changes nothing
+		bytes, _ = service.Invoke("Migrate", []string{})
+		if string(bytes) != fmt.Sprintf("{\"from\":%d,\"to\":%d}", schemaVersion, schemaVersion) {
+			fmt.Println("Second Migrate of version", version, "returned", string(bytes))
+			t.FailNow()
+		}
+
+		// And the migrated state works
+		for _, call := range [][]string{{"AddClaim", "2", "0", "10"}, {"CloseCycle"}, {"RunNetting"}} {
+			if _, err := service.Invoke(call[0], call[1:]); err != nil {
+				fmt.Println("Invoke", call, "on migrated version", version, "failed", err)
+				t.FailNow()
+			}
+		}
+		checkServiceQuery(t, service, "Stats", []string{},
+			"{\"number_of_counter_parties\":3,\"number_of_claims\":0,\"metric_l1\":0,\"metric_l2\":0,\"sum_of_h\":0}")
+	}
+}
+
+// Peers endorsing the same transaction have to write the same bytes
+func TestSchema_SameTableBytes(t *testing.T) {
+	log.Info("\n\nStable table bytes test")
+	reversed := newTable(10, false)
+	for i := len(referenceClaims) - 1; i >= 0; i-- {
+		reversed.AddClaim(referenceClaims[i].From, referenceClaims[i].To, referenceClaims[i].Value)
+	}
+	cfg := defaultConfig()
+	for _, encoding := range []struct{ name string; compress bool }{
+		{encodingJSON, false}, {encodingJSON, true}, {encodingBinary, false}, {encodingBinary, true},
+	} {
+		cfg.Encoding = encoding.name
+		cfg.Compress = encoding.compress
+		expected, _ := encodeTable(referenceTable(), cfg)
+		for i := 0; i < 20; i++ {
+			for _, table := range []Table{referenceTable(), reversed} {
+				if encoded, _ := encodeTable(table, cfg); string(encoded) != string(expected) {
+					fmt.Println("Encoding", encoding, "stored the table as", encoded, "and as", expected)
+					t.FailNow()
+				}
+			}
+		}
+	}
+}
+
+func TestSchema_MigrateOnInvoke(t *testing.T) {
+	log.Info("\n\nSchema migration on invoke test")
+	store := fixtureStore(schemaFixtures[0])
+	service := NewService(store)
+	if _, err := service.Invoke("AddCounterParty", []string{}); err != nil {
+		fmt.Println("AddCounterParty on version 0 failed", err)
+		t.FailNow()
+	}
+	if bytes, _ := store.GetState(schemaVersionKey); string(bytes) != "2" {
+		fmt.Println("Invoke left schema version", string(bytes))
+		t.FailNow()
+	}
+	checkServiceQuery(t, service, "Cycle", []string{}, "{\"open\":1,\"frozen\":0,\"cut_off\":\"\",\"netted\":false}")
+}
+
+func TestSchema_NewerVersion(t *testing.T) {
+	log.Info("\n\nNewer schema version test")
+	store := fixtureStore(schemaFixtures[1])
+	store.PutState(schemaVersionKey, []byte("3"))
+	service := NewService(store)
+	if _, err := service.Query("Stats", []string{}); err == nil {
+		fmt.Println("Query of a newer version did not fail")
+		t.FailNow()
+	}
+	if _, err := service.Invoke("Migrate", []string{}); err == nil {
+		fmt.Println("Migrate of a newer version did not fail")
+		t.FailNow()
+	}
+}
+
+func TestSchema_ValidateAndRepair(t *testing.T) {
+	log.Info("\n\nValidate and repair test")
+	// Counter party 2 repeated and 3 missing, a claim on unknown 7, one negative,
+	// one repeated and claims both ways
+	store := fixtureStore(map[string]string{
+		"NettingTable": "{\"version\":2,\"data\":{\"Nodes\":[0,1,2,2],\"Edges\":[" +
+			"{\"f\":0,\"t\":1,\"v\":10},{\"f\":0,\"t\":1,\"v\":5},{\"f\":1,\"t\":0,\"v\":3}," +
+			"{\"f\":1,\"t\":7,\"v\":4},{\"f\":2,\"t\":1,\"v\":-1}]}}",
+		"OpenCycle":     "{\"version\":2,\"data\":{\"Nodes\":[0,1,2,3],\"Edges\":[]}}",
+		"Cycle":         "{\"open\":2,\"frozen\":1,\"cut_off\":\"\",\"netted\":false}",
+		"SchemaVersion": "2",
+	})
+	service := NewService(store)
+
+	// Nothing that loads it works
+	if _, err := service.Query("Stats", []string{}); err == nil {
+		fmt.Println("Stats of an inconsistent table did not fail")
+		t.FailNow()
+	}
+	if _, err := service.Invoke("CloseCycle", []string{}); err == nil {
+		fmt.Println("CloseCycle of an inconsistent table did not fail")
+		t.FailNow()
+	}
+	problems := "[" +
+		"{\"key\":\"NettingTable\",\"problem\":\"repeated counter party 2\"}," +
+		"{\"key\":\"NettingTable\",\"problem\":\"missing counter party 3\"}," +
+		"{\"key\":\"NettingTable\",\"problem\":\"repeated claim 0 -\\u003e 1\"}," +
+		"{\"key\":\"NettingTable\",\"problem\":\"claims both ways between 1 and 0\"}," +
+		"{\"key\":\"NettingTable\",\"problem\":\"claim 1 -\\u003e 7 of an unknown counter party\"}," +
+		"{\"key\":\"NettingTable\",\"problem\":\"claim 2 -\\u003e 1 of -1 is not positive\"}]"
+	checkServiceQuery(t, service, "Validate", []string{}, problems)
+
+	bytes, err := service.Invoke("Repair", []string{})
+	if err != nil || string(bytes) != problems {
+		fmt.Println("Repair returned", string(bytes), err, "instead of", problems)
+		t.FailNow()
+	}
+	checkServiceQuery(t, service, "Validate", []string{}, "[]")
+	checkServiceQuery(t, service, "Stats", []string{},
+		"{\"number_of_counter_parties\":4,\"number_of_claims\":1,\"metric_l1\":2,\"metric_l2\":4.898979485566356,\"sum_of_h\":0}")
+	checkServiceQuery(t, service, "Claims", []string{"0"}, "[{\"f\":0,\"t\":1,\"v\":12}]")
+
+	// A table which cannot be read at all is emptied
+	store.PutState(openCycleKey, []byte("{\"version\":2,\"data\":\"garbage\"}"))
+	if _, err := service.Invoke("Repair", []string{}); err != nil {
+		fmt.Println("Repair of an unreadable table failed", err)
+		t.FailNow()
+	}
+	checkServiceQuery(t, service, "Validate", []string{}, "[]")
+	checkServiceQuery(t, service, "Claims", []string{"0"}, "[{\"f\":0,\"t\":1,\"v\":12}]")
+}
+
+func TestEncoding_Binary(t *testing.T) {
+	log.Info("\n\nBinary encoding test")
+	table := referenceTable()
+	cfg := defaultConfig()
+	cfg.Precision = 2
+	jsonBytes, _ := encodeTable(table, cfg)
+	expected := fmt.Sprint(getAllClaims(table))
+
+	for _, encoding := range []struct{ name string; compress bool }{
+		{encodingJSON, true}, {encodingBinary, false}, {encodingBinary, true},
+	} {
+		cfg.Encoding = encoding.name
+		cfg.Compress = encoding.compress
+		encoded, err := encodeTable(table, cfg)
+		if err != nil || len(encoded) >= len(jsonBytes) {
+			fmt.Println("Encoding", encoding, "took", len(encoded), "bytes instead of", len(jsonBytes), err)
+			t.FailNow()
+		}
+		stored, err := decodeTable(encoded)
+		if err != nil || len(checkTable(stored, false)) > 0 || fmt.Sprint(getAllClaims(stored.toTable())) != expected {
+			fmt.Println("Encoding", encoding, "did not keep the table", err)
+			t.FailNow()
+		}
+	}
+
+	// Amounts are rounded as configured, half to even
+	half := newTable(2, false)
+	half.AddClaim(0, 1, 2.675)
+	cfg.Compress = false
+	encoded, _ := encodeTable(half, cfg)
+	if stored, _ := decodeTable(encoded); fmt.Sprint(stored.Edges) != "[{0 1 2.68}]" {
+		fmt.Println("Binary claims", stored.Edges, "instead of 2.68")
+		t.FailNow()
+	}
+
+	// Broken or cut short
+	encoded, _ = encodeTable(table, cfg)
+	for _, broken := range [][]byte{encoded[:len(encoded)-1], append(encoded, 0), binaryMagic} {
+		if _, err := decodeTable(broken); err == nil {
+			fmt.Println("Broken binary table", broken, "was decoded")
+			t.FailNow()
+		}
+	}
+}
+
+func TestEncoding_Switch(t *testing.T) {
+	log.Info("\n\nSwitching encoding test")
+	service := NewService(NewMemoryStore())
+	store := service.store
+	service.Init([]string{})
+	for _, call := range [][]string{{"AddCounterParty"}, {"AddCounterParty"}, {"AddClaim", "0", "1", "2.5"}} {
+		service.Invoke(call[0], call[1:])
+	}
+
+	// Existing JSON tables are read until they are written again
+	if _, err := service.Invoke("UpdateConfig", []string{"{\"encoding\":\"binary\",\"compress\":true,\"precision\":2}"}); err != nil {
+		fmt.Println("UpdateConfig failed", err)
+		t.FailNow()
+	}
+	checkServiceQuery(t, service, "Claims", []string{"0"}, "[{\"f\":0,\"t\":1,\"v\":2.5}]")
+	service.Invoke("AddClaim", []string{"1", "0", "1"})
+	if bytes, _ := store.GetState(openCycleKey); !strings.HasPrefix(string(bytes), string(gzipMagic)) {
+		fmt.Println("Open cycle was not compressed", bytes)
+		t.FailNow()
+	}
+	checkServiceQuery(t, service, "Claims", []string{"0"}, "[{\"f\":0,\"t\":1,\"v\":1.5}]")
+	checkServiceQuery(t, service, "Validate", []string{}, "[]")
+
+	if _, err := service.Invoke("UpdateConfig", []string{"{\"precision\":-1}"}); err == nil {
+		fmt.Println("Binary encoding without a precision was accepted")
+		t.FailNow()
+	}
+}
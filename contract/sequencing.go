@@ -0,0 +1,199 @@
+package contract
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// A pending instruction that can be paid, in the order of payment.
+// Instructions of the same step settle at once, as they do in an offsetting step.
+type sequencedPayment struct {
+	Id     int     `json:"id"`
+	Payer  int     `json:"payer"`
+	Payee  int     `json:"payee"`
+	Amount float64 `json:"amount"`
+	Step   int     `json:"step"`
+	// Paid by offsetting it against incoming payments rather than from the balance
+	Offset bool `json:"offset"`
+}
+
+// A pending instruction that cannot be paid with the liquidity there is
+type unsettledPayment struct {
+	Id        int     `json:"id"`
+	Payer     int     `json:"payer"`
+	Payee     int     `json:"payee"`
+	Amount    float64 `json:"amount"`
+	Shortfall float64 `json:"shortfall"`
+	Reason    string  `json:"reason"`
+}
+
+// Returned by the Sequence query
+type sequencingReport struct {
+	Settled   []sequencedPayment `json:"settled"`
+	Unsettled []unsettledPayment `json:"unsettled"`
+	// Of every counter party after the settled payments
+	Balances []float64 `json:"balances"`
+}
+
+// Orders the pending settlement instructions so that the payers can afford them.
+// The queue is run through in the order of instructions, every payment the payer
+// has the balance for is made, the rest wait for incoming payments. When the queue
+// is stuck, the waiting payments are offset against each other: payments of the
+// counter parties that would still be short are taken out from the end of the queue
+// until the others settle at once (gridlock resolution of RTGS systems).
+// args: Liquidity json ({"CounterPartyId": amount, ...}, 0 for the others)
+func (this smartContract) query_Sequence(store Store, args []string) ([]byte, error) {
+	message := fmt.Sprintf("querySequence called with args: %s\n", args)
+	log.Debugf(message)
+
+	// Check arguments
+	if len(args) < 1 {
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	liquidity := map[int]float64{}
+	if err := json.Unmarshal([]byte(args[0]), &liquidity); err != nil {
+		log.Errorf("json.Unmarshal(args[0], &liquidity) error: %s", err.Error())
+		return nil, err
+	}
+
+	// Load existing data
+	nettingTable, err := load(store)
+	checkCriticalError(err)
+	instructions, err := loadSettlements(store)
+	checkCriticalError(err)
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+
+	balances := make([]float64, counterParties(nettingTable))
+	for id, amount := range liquidity {
+		if !hasCounterParty(nettingTable, id) || !(amount >= 0.0) {
+			message := fmt.Sprintf("liquidity of %v for counter party %d is not valid\n", amount, id)
+			log.Errorf(message)
+			return nil, errors.New(message)
+		}
+		balances[id] = amount
+	}
+	queue := []settlementInstruction{}
+	for _, instruction := range instructions {
+		if instruction.Status == settlementPending {
+			queue = append(queue, instruction)
+		}
+	}
+
+	report := sequence(queue, balances, cfg)
+
+	bts, err := json.Marshal(report)
+	if err != nil {
+		log.Errorf("json.Marshal(report) error: %s", err.Error())
+		return nil, err
+	}
+
+	return bts, nil
+}
+
+// Balances are changed by the payments made
+func sequence(queue []settlementInstruction, balances []float64, cfg *config) *sequencingReport {
+	report := &sequencingReport{Settled: []sequencedPayment{}, Unsettled: []unsettledPayment{}}
+	affords := func(balance float64, amount float64) bool {
+		return balance >= amount || cfg.isZero(amount-balance)
+	}
+	pay := func(instruction settlementInstruction, step int, offset bool) {
+		balances[instruction.Payer] = cfg.round(balances[instruction.Payer] - instruction.Amount)
+		balances[instruction.Payee] = cfg.round(balances[instruction.Payee] + instruction.Amount)
+		report.Settled = append(report.Settled, sequencedPayment{Id: instruction.Id, Payer: instruction.Payer,
+			Payee: instruction.Payee, Amount: instruction.Amount, Step: step, Offset: offset})
+	}
+
+	step := 0
+	for len(queue) > 0 {
+		// Payments one by one as long as any of them can be made
+		for progress := true; progress; {
+			progress = false
+			waiting := []settlementInstruction{}
+			for _, instruction := range queue {
+				if affords(balances[instruction.Payer], instruction.Amount) {
+					step++
+					pay(instruction, step, false)
+					progress = true
+				} else {
+					waiting = append(waiting, instruction)
+				}
+			}
+			queue = waiting
+		}
+		if len(queue) == 0 {
+			break
+		}
+
+		// Gridlock: a batch of waiting payments that settles at once
+		batch := offsetBatch(queue, balances, affords)
+		if len(batch) == 0 {
+			break
+		}
+		step++
+		inBatch := map[int]bool{}
+		for _, instruction := range batch {
+			inBatch[instruction.Id] = true
+			pay(instruction, step, true)
+		}
+		rest := []settlementInstruction{}
+		for _, instruction := range queue {
+			if !inBatch[instruction.Id] {
+				rest = append(rest, instruction)
+			}
+		}
+		queue = rest
+	}
+
+	for _, instruction := range queue {
+		shortfall := cfg.round(instruction.Amount - balances[instruction.Payer])
+		report.Unsettled = append(report.Unsettled, unsettledPayment{Id: instruction.Id, Payer: instruction.Payer,
+			Payee: instruction.Payee, Amount: instruction.Amount, Shortfall: shortfall,
+			Reason: fmt.Sprintf("payer %d has %v of %v", instruction.Payer, balances[instruction.Payer], instruction.Amount)})
+	}
+	report.Balances = balances
+	return report
+}
+
+// The largest batch found by taking the last queued payment of every counter party
+// which would end up short, until none would. Empty when there is none.
+func offsetBatch(queue []settlementInstruction, balances []float64,
+	affords func(balance float64, amount float64) bool) []settlementInstruction {
+	batch := append([]settlementInstruction{}, queue...)
+	for len(batch) > 0 {
+		incoming := make([]float64, len(balances))
+		outgoing := make([]float64, len(balances))
+		for _, instruction := range batch {
+			outgoing[instruction.Payer] += instruction.Amount
+			incoming[instruction.Payee] += instruction.Amount
+		}
+
+		short := map[int]bool{}
+		for id := range balances {
+			if !affords(balances[id]+incoming[id], outgoing[id]) {
+				short[id] = true
+			}
+		}
+		if len(short) == 0 {
+			return batch
+		}
+
+		kept := []settlementInstruction{}
+		for i := len(batch) - 1; i >= 0; i-- {
+			if short[batch[i].Payer] {
+				// Only the last payment of every short counter party goes
+				delete(short, batch[i].Payer)
+				continue
+			}
+			kept = append(kept, batch[i])
+		}
+		// Back to the queue order
+		for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+			kept[i], kept[j] = kept[j], kept[i]
+		}
+		batch = kept
+	}
+	return batch
+}
@@ -0,0 +1,155 @@
+package contract
+
+import (
+	"time"
+)
+
+// Runs the smart contract off-chain on any Store, e.g. in batch jobs.
+// Like on the ledger, an invoke that fails changes nothing and emits no event.
+// There are no callers off-chain, so anyone is the admin.
+type Service struct {
+	store Store
+	// Time of the transactions
+	Clock func() time.Time
+	// Receives the chaincode events, may be nil
+	Events func(name string, payload []byte) error
+}
+
+func NewService(store Store) *Service {
+	return &Service{store: store, Clock: time.Now}
+}
+
+// args: [Config json]
+func (this *Service) Init(args []string) ([]byte, error) {
+	log.Debugf("Service Init called with arguments: %s", args)
+
+	return this.transact(func(s smartContract, store Store) ([]byte, error) {
+		return s.initSmartContract(store, args)
+	})
+}
+
+func (this *Service) Invoke(function string, args []string) (result []byte, err error) {
+	defer recoverCriticalError(&err)
+	log.Debugf("Service Invoke called with function name: %s, with arguments: %s", function, args)
+
+	f, ok := invokes[function]
+	if ok {
+		return this.transact(func(s smartContract, store Store) ([]byte, error) {
+			if err := migrateForInvoke(function, store); err != nil {
+				return nil, err
+			}
+			return f(s, store, args)
+		})
+	}
+	return nil, nil
+}
+
+func (this *Service) Query(function string, args []string) (result []byte, err error) {
+	defer recoverCriticalError(&err)
+	log.Debugf("Service Query called with function name: %s, with arguments: %s", function, args)
+
+	f, ok := queries[function]
+	if ok {
+		store, err := migratedView(function, this.store)
+		if err != nil {
+			return nil, err
+		}
+		return f(smartContract{}, store, args)
+	}
+	return nil, nil
+}
+
+type serviceEvent struct {
+	name    string
+	payload []byte
+}
+
+func (this *Service) transact(f func(smartContract, Store) ([]byte, error)) ([]byte, error) {
+	tx := newTxStore(this.store)
+	events := []serviceEvent{}
+	s := smartContract{
+		txTime: this.Clock().UTC(),
+		events: func(name string, payload []byte) error {
+			events = append(events, serviceEvent{name: name, payload: payload})
+			return nil
+		},
+	}
+
+	result, err := f(s, tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.commit(); err != nil {
+		return nil, err
+	}
+
+	if this.Events != nil {
+		for _, e := range events {
+			if err := this.Events(e.name, e.payload); err != nil {
+				log.Errorf("Events(%s) error: %s", e.name, err.Error())
+				return nil, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Changes of one transaction, they get to the store on commit
+type txStore struct {
+	store   Store
+	writes  map[string][]byte
+	deleted map[string]bool
+}
+
+func newTxStore(store Store) *txStore {
+	return &txStore{store: store, writes: make(map[string][]byte), deleted: make(map[string]bool)}
+}
+
+func (this *txStore) GetState(key string) ([]byte, error) {
+	if this.deleted[key] {
+		return nil, nil
+	}
+	if value, ok := this.writes[key]; ok {
+		return value, nil
+	}
+	return this.store.GetState(key)
+}
+
+func (this *txStore) PutState(key string, value []byte) error {
+	delete(this.deleted, key)
+	this.writes[key] = value
+	return nil
+}
+
+func (this *txStore) DelState(key string) error {
+	delete(this.writes, key)
+	this.deleted[key] = true
+	return nil
+}
+
+// Changes of the transaction over what is in the store
+func (this *txStore) RangeQueryState(startKey string, endKey string) (map[string][]byte, error) {
+	entries, err := this.store.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	for key := range this.deleted {
+		delete(entries, key)
+	}
+	for key, value := range this.writes {
+		if key >= startKey && key <= endKey {
+			entries[key] = value
+		}
+	}
+	return entries, nil
+}
+
+// Nested changes become part of this transaction
+func (this *txStore) Commit(writes map[string][]byte, deleted map[string]bool) error {
+	return commitEach(this, writes, deleted)
+}
+
+func (this *txStore) commit() error {
+	return this.store.Commit(this.writes, this.deleted)
+}
@@ -0,0 +1,333 @@
+package contract
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const settlementsKey string = "Settlements"
+
+// Certificate of the caller who has registered a counter party, only its holder
+// may confirm or fail the settlements of the counter party
+const identityKeyPrefix string = "Identity."
+
+const defaultCurrency string = "USD"
+
+const valueDateLayout string = "2006-01-02"
+
+// Settlement instruction statuses
+const (
+	settlementPending   string = "pending"
+	settlementConfirmed string = "confirmed"
+	settlementFailed    string = "failed"
+)
+
+// A claim of "f" on "t" that is left after netting is paid by "t" to "f".
+// The claim stays in the table until both payer and payee confirm the payment.
+type settlementInstruction struct {
+	Id        int     `json:"id"`
+	Payer     int     `json:"payer"`
+	Payee     int     `json:"payee"`
+	Amount    float64 `json:"amount"`
+	Currency  string  `json:"currency"`
+	ValueDate string  `json:"value_date"`
+	// Class of the claim paid, empty for the first class
+	Class            string `json:"class,omitempty"`
+	Status           string `json:"status"`
+	ConfirmedByPayer bool   `json:"confirmed_by_payer"`
+	ConfirmedByPayee bool   `json:"confirmed_by_payee"`
+}
+
+// Creates pending instructions for every claim left in the table of the class
+func makeSettlements(this Table, instructions []settlementInstruction,
+	currency string, valueDate string, class string) []settlementInstruction {
+	for _, c := range getAllClaims(this) {
+		instructions = append(instructions, settlementInstruction{
+			Id:        len(instructions),
+			Payer:     c.To,
+			Payee:     c.From,
+			Amount:    c.Value,
+			Currency:  currency,
+			ValueDate: valueDate,
+			Class:     class,
+			Status:    settlementPending,
+		})
+	}
+	return instructions
+}
+
+func hasPendingSettlements(instructions []settlementInstruction) bool {
+	for _, instruction := range instructions {
+		if instruction.Status == settlementPending {
+			return true
+		}
+	}
+	return false
+}
+
+// args: [Currency string, [ValueDate string]]
+func parseSettlementArgs(txTime time.Time, cfg *config, args []string) (currency string, valueDate string, err error) {
+	requested := ""
+	if len(args) > 0 {
+		requested = args[0]
+	}
+	if currency, err = cfg.currency(requested); err != nil {
+		return
+	}
+	if len(args) > 1 && args[1] != "" {
+		if _, err = time.Parse(valueDateLayout, args[1]); err != nil {
+			log.Errorf("time.Parse(valueDateLayout, args[1]) error: %s", err.Error())
+			return
+		}
+		valueDate = args[1]
+		return
+	}
+	// Same day settlement by default
+	if !txTime.IsZero() {
+		valueDate = txTime.UTC().Format(valueDateLayout)
+	}
+	return
+}
+
+// The caller has to be the one who has registered the counter party.
+// args: InstructionId int, CounterPartyId int
+func (this smartContract) invoke_ConfirmSettlement(store Store, args []string) ([]byte, error) {
+	message := fmt.Sprintf("invokeConfirmSettlement called with args: %s\n", args)
+	log.Debugf(message)
+
+	// Check arguments
+	if len(args) < 2 {
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+
+	// Load existing data
+	instructions, err := loadSettlements(store)
+	checkCriticalError(err)
+
+	instruction, err := findSettlement(instructions, args)
+	if err != nil {
+		return nil, err
+	}
+	counterPartyId, _ := strconv.Atoi(args[1])
+	if err := this.checkCounterParty(counterPartyId, store); err != nil {
+		return nil, err
+	}
+	if counterPartyId == instruction.Payer {
+		instruction.ConfirmedByPayer = true
+	}
+	if counterPartyId == instruction.Payee {
+		instruction.ConfirmedByPayee = true
+	}
+
+	settled := instruction.ConfirmedByPayer && instruction.ConfirmedByPayee
+	if settled {
+		instruction.Status = settlementConfirmed
+
+		cfg, err := loadConfig(store)
+		checkCriticalError(err)
+		class, err := cfg.classIndex(instruction.Class)
+		checkCriticalError(err)
+		tables, err := loadClasses(storeKey, store, cfg)
+		checkCriticalError(err)
+
+		// The payment takes the paid claim off
+		nettingTable := tables[class]
+		reduceClaim(nettingTable, instruction.Payee, instruction.Payer, instruction.Amount)
+		nettingTable = normalizeTable(nettingTable, cfg)
+
+		err = saveTable(nettingTable, classKey(storeKey, class, cfg), store)
+		checkCriticalError(err)
+	}
+
+	// Save new data
+	err = saveSettlements(instructions, store)
+	checkCriticalError(err)
+
+	if settled {
+		if err := this.emitEvent(eventSettlementConfirmed, *instruction); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+// The caller has to be the one who has registered the counter party.
+// args: InstructionId int, CounterPartyId int
+func (this smartContract) invoke_FailSettlement(store Store, args []string) ([]byte, error) {
+	message := fmt.Sprintf("invokeFailSettlement called with args: %s\n", args)
+	log.Debugf(message)
+
+	// Check arguments
+	if len(args) < 2 {
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+
+	// Load existing data
+	instructions, err := loadSettlements(store)
+	checkCriticalError(err)
+
+	instruction, err := findSettlement(instructions, args)
+	if err != nil {
+		return nil, err
+	}
+	counterPartyId, _ := strconv.Atoi(args[1])
+	if err := this.checkCounterParty(counterPartyId, store); err != nil {
+		return nil, err
+	}
+	// The claim stays in the table and is netted again by the next run
+	instruction.Status = settlementFailed
+
+	// Save new data
+	err = saveSettlements(instructions, store)
+	checkCriticalError(err)
+
+	if err := this.emitEvent(eventSettlementFailed, *instruction); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+// args: [Status string]
+func (this smartContract) query_Settlements(store Store, args []string) ([]byte, error) {
+	log.Debugf("querySettlements called with args: %s\n", args)
+
+	// Load existing data
+	instructions, err := loadSettlements(store)
+	checkCriticalError(err)
+
+	result := []settlementInstruction{}
+	for _, instruction := range instructions {
+		if len(args) == 0 || args[0] == "" || args[0] == instruction.Status {
+			result = append(result, instruction)
+		}
+	}
+
+	bts, err := json.Marshal(result)
+	if err != nil {
+		log.Errorf("json.Marshal(result) error: %s", err.Error())
+		return nil, err
+	}
+
+	return bts, nil
+}
+
+// args: InstructionId int, CounterPartyId int
+// Only a pending instruction can be changed and only by its payer or payee
+func findSettlement(instructions []settlementInstruction, args []string) (*settlementInstruction, error) {
+	instructionId, err := strconv.Atoi(args[0])
+	if err != nil {
+		log.Errorf("strconv.Atoi(args[0]) error: %s", err.Error())
+		return nil, err
+	}
+	counterPartyId, err := strconv.Atoi(args[1])
+	if err != nil {
+		log.Errorf("strconv.Atoi(args[1]) error: %s", err.Error())
+		return nil, err
+	}
+
+	if instructionId < 0 || instructionId >= len(instructions) {
+		message := fmt.Sprintf("settlement instruction %d does not exist\n", instructionId)
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	instruction := &instructions[instructionId]
+	if instruction.Status != settlementPending {
+		message := fmt.Sprintf("settlement instruction %d is %s\n", instructionId, instruction.Status)
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	if counterPartyId != instruction.Payer && counterPartyId != instruction.Payee {
+		message := fmt.Sprintf("counter party %d is neither payer nor payee of settlement instruction %d\n",
+			counterPartyId, instructionId)
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+
+	return instruction, nil
+}
+
+// The caller has to sign as the one who has registered the counter party
+func (this smartContract) checkCounterParty(counterPartyId int, store Store) error {
+	if this.signedBy == nil {
+		return nil
+	}
+	identity, err := store.GetState(identityKeyPrefix + strconv.Itoa(counterPartyId))
+	if err != nil {
+		log.Errorf("store.GetState(identityKeyPrefix + counterPartyId) error: %s", err.Error())
+		return err
+	}
+	ok, err := this.signedBy(identity)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		message := fmt.Sprintf("only the caller who has registered counter party %d may do this\n", counterPartyId)
+		log.Errorf(message)
+		return errors.New(message)
+	}
+	return nil
+}
+
+// Without a caller certificate, e.g. off-chain, the counter party has no identity.
+// IDs are used again after Clear, so an identity is always replaced.
+func saveIdentity(counterPartyId int, certificate []byte, store Store) error {
+	key := identityKeyPrefix + strconv.Itoa(counterPartyId)
+	if len(certificate) == 0 {
+		err := store.DelState(key)
+		if err != nil {
+			log.Errorf("store.DelState(identityKeyPrefix + counterPartyId) error: %s", err.Error())
+			return err
+		}
+		return nil
+	}
+	err := store.PutState(key, certificate)
+	if err != nil {
+		log.Errorf("store.PutState(identityKeyPrefix + counterPartyId, certificate) error: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+func saveSettlements(instructions []settlementInstruction, store Store) error {
+	log.Debugf("Saving settlements...\n")
+
+	bytes, err := json.Marshal(instructions)
+	if err != nil {
+		log.Errorf("json.Marshal(instructions) error: %s", err.Error())
+		return err
+	}
+	err = store.PutState(settlementsKey, bytes)
+	if err != nil {
+		log.Errorf("store.PutState(settlementsKey, bytes) error: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func loadSettlements(store Store) ([]settlementInstruction, error) {
+	log.Debugf("Loading settlements...\n")
+
+	bytes, err := store.GetState(settlementsKey)
+	if err != nil {
+		log.Errorf("store.GetState(settlementsKey) error: %s", err.Error())
+		return nil, err
+	}
+
+	instructions := []settlementInstruction{}
+	if len(bytes) == 0 {
+		return instructions, nil
+	}
+	err = json.Unmarshal(bytes, &instructions)
+	if err != nil {
+		log.Errorf("json.Unmarshal(bytes, &instructions) error: %s", err.Error())
+		return nil, err
+	}
+
+	return instructions, nil
+}
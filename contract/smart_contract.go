@@ -0,0 +1,738 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+	"errors"
+	"github.com/VladimirStarostenkov/netting"
+)
+
+const storeKey string = "NettingTable"
+
+var invokes map[string]func(smartContract, Store, []string) ([]byte, error) =
+	map[string]func(smartContract, Store, []string) ([]byte, error) {
+		"AddClaim":(smartContract).invoke_AddClaim,
+		"CancelClaim":(smartContract).invoke_CancelClaim,
+		"AddCounterParty":(smartContract).invoke_AddCounterParty,
+		"CloseCycle":(smartContract).invoke_CloseCycle,
+		"RunNetting":(smartContract).invoke_RunNetting,
+		"SubmitNettingResult":(smartContract).invoke_SubmitNettingResult,
+		"ConfirmSettlement":(smartContract).invoke_ConfirmSettlement,
+		"FailSettlement":(smartContract).invoke_FailSettlement,
+		"Clear":(smartContract).invoke_Clear,
+		"UpdateConfig":(smartContract).invoke_UpdateConfig,
+		"Migrate":(smartContract).invoke_Migrate,
+		"Repair":(smartContract).invoke_Repair,
+		"SetLimit":(smartContract).invoke_SetLimit,
+}
+
+var queries map[string]func(smartContract, Store, []string) ([]byte, error) =
+	map[string]func(smartContract, Store, []string) ([]byte, error) {
+		"Stats":(smartContract).query_Stats,
+		"Graph":(smartContract).query_Graph,
+		"Claims":(smartContract).query_Claims,
+		"Settlements":(smartContract).query_Settlements,
+		"Cycle":(smartContract).query_Cycle,
+		"Config":(smartContract).query_Config,
+		"Validate":(smartContract).query_Validate,
+		"Exposures":(smartContract).query_Exposures,
+		"Sequence":(smartContract).query_Sequence,
+		"Savings":(smartContract).query_Savings,
+		"Cycles":(smartContract).query_Cycles,
+		"Trace":(smartContract).query_Trace,
+}
+
+// Transaction the smart contract runs in
+type smartContract struct {
+	// Zero when unknown
+	txTime time.Time
+	// Binding of the transaction, empty when unknown
+	txID string
+	// Certificate of the caller, the admin is the caller of Init
+	caller []byte
+	// Whether the caller has signed the transaction with the key of a certificate,
+	// nil - off-chain, where there are no callers and anyone is the admin
+	signedBy func(certificate []byte) (bool, error)
+	// Chaincode events go here, nil - nowhere
+	events func(name string, payload []byte) error
+}
+
+// args: [Config json]
+func (this smartContract) initSmartContract(store Store, args []string) ([]byte, error) {
+	log.Debugf("init called with args: %s\n", args)
+
+	if err := this.initConfig(store, args); err != nil {
+		return nil, err
+	}
+	if err := saveLimits(newExposureLimits(), store); err != nil {
+		return nil, err
+	}
+	return this.clearSmartContract(store)
+}
+
+// Everything except what the admin has set, the configuration and the exposure limits,
+// starts from scratch, idempotency keys too. Anyone may clear, so the limits are kept
+// for the counter parties added again with the same IDs.
+func (this smartContract) clearSmartContract(store Store) ([]byte, error) {
+	nettingTable := newTable(0, false)
+
+	generation, err := loadGeneration(store)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveGeneration(generation+1, store); err != nil {
+		return nil, err
+	}
+	// Netting runs, their savings reports and claim records of every cycle
+	for _, prefix := range []string{nettingRunKeyPrefix, savingsKeyPrefix, claimRecordsKeyPrefix} {
+		entries, err := prefixQuery(store, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range sortedKeys(entries) {
+			if err := store.DelState(key); err != nil {
+				log.Errorf("store.DelState(key) error: %s", err.Error())
+				return nil, err
+			}
+		}
+	}
+	if err := saveNettingRuns([]int{}, store); err != nil {
+		return nil, err
+	}
+
+	if err := save(nettingTable, store); err != nil {
+		return nil, err
+	}
+	if err := saveTable(emptyCopy(nettingTable), openCycleKey, store); err != nil {
+		return nil, err
+	}
+	// Tables of the other classes are made again when used
+	cfg, err := loadConfig(store)
+	if err != nil {
+		return nil, err
+	}
+	for class := 1; class < len(cfg.Classes); class++ {
+		for _, key := range []string{storeKey, openCycleKey} {
+			if err := store.DelState(classKey(key, class, cfg)); err != nil {
+				log.Errorf("store.DelState(classKey) error: %s", err.Error())
+				return nil, err
+			}
+		}
+	}
+	if err := saveCycle(&cycleState{Open: 1}, store); err != nil {
+		return nil, err
+	}
+	if err := saveSettlements([]settlementInstruction{}, store); err != nil {
+		return nil, err
+	}
+	if err := saveSchemaVersion(schemaVersion, store); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+// args: From int, To int, Value float, [IdempotencyKey string, [Class string]]
+func (this smartContract) invoke_AddClaim(store Store, args []string) ([]byte, error) {
+	message := fmt.Sprintf("invokeAddClaim called with args: %s\n", args)
+	log.Debugf(message)
+
+	// Check arguments
+	if len(args) < 3 {
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	c, err := parseClaim(args)
+	if err != nil {
+		return nil, err
+	}
+	key := ""
+	if len(args) > 3 {
+		key = args[3]
+	}
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+	c.Value = cfg.round(c.Value)
+	class, err := classOf(args, 4, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// We are not interested in "negative claims"
+	if c.Value < 0.0 {
+		return nil, nil
+	}
+
+	// A retry of an already processed claim is not applied again
+	if key != "" {
+		receipt, bytes, err := loadReceipt(key, store)
+		checkCriticalError(err)
+		if receipt != nil {
+			if err := checkRetry(receipt, c, cfg.classLabel(class)); err != nil {
+				return nil, err
+			}
+			log.Debugf("invokeAddClaim: claim with key %s was already processed\n", key)
+			return bytes, nil
+		}
+	}
+
+	// Load existing data, new claims go into the open cycle
+	cycle, err := loadCycle(store)
+	checkCriticalError(err)
+	openTables, err := loadClasses(openCycleKey, store, cfg)
+	checkCriticalError(err)
+	openTable := openTables[class]
+
+	// Claims to self, to unknown counter parties or of zero value are ignored by the table
+	applied := c.From != c.To && !cfg.isZero(c.Value) &&
+		hasCounterParty(openTable, c.From) && hasCounterParty(openTable, c.To)
+
+	// The debtor's exposures may not grow beyond its limits
+	if applied {
+		limits, err := loadLimits(store)
+		checkCriticalError(err)
+		before, err := loadAllClasses(store, cfg)
+		checkCriticalError(err)
+		after, err := loadAllClasses(store, cfg)
+		checkCriticalError(err)
+		after[class].AddClaim(c.From, c.To, c.Value)
+		if err := limits.check(before, after, c.To, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if applied {
+		openTable.AddClaim(c.From, c.To, c.Value)
+		openTable = normalizeTable(openTable, cfg)
+	}
+
+	// Save new data
+	err = saveTable(openTable, classKey(openCycleKey, class, cfg), store)
+	checkCriticalError(err)
+	receipt := &claimReceipt{claim: c, Class: cfg.classLabel(class), Cycle: cycle.Open, Applied: applied, Key: key}
+	bytes, err := saveReceipt(receipt, store)
+	checkCriticalError(err)
+	if applied {
+		err = this.addClaimRecord(claimRecord{claim: c, Class: cfg.classLabel(class), Key: key, Cycle: cycle.Open}, store)
+		checkCriticalError(err)
+	}
+
+	if applied {
+		if err := this.emitEvent(eventClaimAdded, claimEvent(c)); err != nil {
+			return nil, err
+		}
+	}
+
+	return bytes, nil
+}
+// args: From int, To int, Value float, [Class string]
+func (this smartContract) invoke_CancelClaim(store Store, args []string) ([]byte, error) {
+	message := fmt.Sprintf("invokeCancelClaim called with args: %s\n", args)
+	log.Debugf(message)
+
+	// Check arguments
+	if len(args) < 3 {
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	c, err := parseClaim(args)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+	c.Value = cfg.round(c.Value)
+	class, err := classOf(args, 3, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if c.Value <= 0.0 {
+		message = fmt.Sprintf("invokeCancelClaim: value to cancel must be positive, got %v\n", c.Value)
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+
+	// Load existing data, claims of closed cycles are frozen
+	cycle, err := loadCycle(store)
+	checkCriticalError(err)
+	openTables, err := loadClasses(openCycleKey, store, cfg)
+	checkCriticalError(err)
+	openTable := openTables[class]
+
+	// Only a claim of the open cycle can be cancelled, and not more than it is worth
+	outstanding := 0.0
+	for _, existing := range getClaims(openTable, c.From) {
+		if existing.To == c.To && existing.Value > 0.0 {
+			outstanding = existing.Value
+		}
+	}
+	if c.Value > outstanding {
+		message = fmt.Sprintf("invokeCancelClaim: claim %d -> %d in the open cycle is %v, cannot cancel %v\n",
+			c.From, c.To, outstanding, c.Value)
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+
+	// Takes the value off the existing claim
+	reduceClaim(openTable, c.From, c.To, c.Value)
+	openTable = normalizeTable(openTable, cfg)
+
+	// Save new data
+	err = saveTable(openTable, classKey(openCycleKey, class, cfg), store)
+	checkCriticalError(err)
+	cancelled := claimRecord{claim: claim{From: c.From, To: c.To, Value: -c.Value}, Class: cfg.classLabel(class), Cycle: cycle.Open}
+	err = this.addClaimRecord(cancelled, store)
+	checkCriticalError(err)
+
+	if err := this.emitEvent(eventClaimCancelled, claimEvent(c)); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+// The caller becomes the identity of the counter party, which confirms its settlements.
+// args: -
+func (this smartContract) invoke_AddCounterParty(store Store, args []string) ([]byte, error) {
+	log.Debugf("invokeAddNode called with args: %s\n", args)
+
+	// Load existing data
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+	nettingTable, err := loadClassTable(storeKey, store, cfg.isGross(0))
+	checkCriticalError(err)
+	openTable, err := loadClassTable(openCycleKey, store, cfg.isGross(0))
+	checkCriticalError(err)
+
+	if cfg.MaxCounterParties > 0 && counterParties(nettingTable) >= cfg.MaxCounterParties {
+		message := fmt.Sprintf("invokeAddCounterParty: limit of %d counter parties is reached\n", cfg.MaxCounterParties)
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+
+	// Both tables know every counter party under the same ID
+	counterPartyId := nettingTable.AddCounterParty()
+	_ = openTable.AddCounterParty()
+
+	// Save new data
+	err = save(nettingTable, store)
+	checkCriticalError(err)
+	err = saveTable(openTable, openCycleKey, store)
+	checkCriticalError(err)
+	err = saveIdentity(counterPartyId, this.caller, store)
+	checkCriticalError(err)
+
+	if err := this.emitEvent(eventCounterPartyRegistered, counterPartyEvent{CounterPartyId: counterPartyId}); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+// args: [Currency string, [ValueDate string, [Algorithm string, [Participants string, [Threshold float]]]]]
+// Participants are comma separated counter party IDs, by default everyone participates.
+// Claims below the threshold, the configured one by default, are not netted.
+func (this smartContract) invoke_RunNetting(store Store, args []string) ([]byte, error) {
+	log.Debugf("invokeRunNetting called with args: %s\n", args)
+
+	// Check arguments
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+	currency, valueDate, err := parseSettlementArgs(this.txTime, cfg, args)
+	if err != nil {
+		return nil, err
+	}
+	algorithm := ""
+	if len(args) > 2 {
+		algorithm = args[2]
+	}
+	if len(args) > 4 && args[4] != "" {
+		threshold, err := strconv.ParseFloat(args[4], 64)
+		if err != nil {
+			log.Errorf("strconv.ParseFloat(args[4]) error: %s", err.Error())
+			return nil, err
+		}
+		// For this run only
+		cfg.NettingThreshold = threshold
+		if err := cfg.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Load existing data
+	tables, err := loadForNetting(store, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	participants := []int(nil)
+	if len(args) > 3 {
+		if participants, err = parseParticipants(tables[0], args[3]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Run netting algorithm
+	netted, report, err := optimizeClasses(tables, cfg, algorithm, participants)
+	if err != nil {
+		return nil, err
+	}
+
+	return this.completeNetting(store, tables, netted, report, currency, valueDate)
+}
+
+// Only the frozen cycle is netted, and only when nothing is being settled.
+// Tables of every class are returned.
+func loadForNetting(store Store, cfg *config) ([]Table, error) {
+	cycle, err := loadCycle(store)
+	checkCriticalError(err)
+	if err := checkCycleFrozen(cycle); err != nil {
+		return nil, err
+	}
+	tables, err := loadClasses(storeKey, store, cfg)
+	checkCriticalError(err)
+	instructions, err := loadSettlements(store)
+	checkCriticalError(err)
+
+	// Claims under pending instructions may be paid any moment, they cannot be netted
+	if hasPendingSettlements(instructions) {
+		message := "settlement of the previous netting run is still pending\n"
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+
+	return tables, nil
+}
+
+// Replaces the frozen claims of every class with the netted ones, which have to be paid now
+func (this smartContract) completeNetting(store Store, tables []Table, netted []Table,
+	report *nettingReport, currency string, valueDate string) ([]byte, error) {
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+	cycle, err := loadCycle(store)
+	checkCriticalError(err)
+	instructions, err := loadSettlements(store)
+	checkCriticalError(err)
+
+	// What is left between the participants has to be paid, class by class
+	for class := range netted {
+		settled, _ := splitTable(netted[class], report.Participants)
+		instructions = makeSettlements(settled, instructions, currency, valueDate, cfg.classLabel(class))
+	}
+	cycle.Netted = true
+	savings := newSavingsReport(cycle.Frozen, report.Algorithm, tables, netted, cfg)
+
+	// Save new data
+	err = saveClasses(netted, storeKey, store, cfg)
+	checkCriticalError(err)
+	err = saveSavings(&savings, store)
+	checkCriticalError(err)
+	runs, err := loadNettingRuns(store)
+	checkCriticalError(err)
+	since := 0
+	if len(runs) > 0 {
+		since = runs[len(runs)-1]
+	}
+	err = saveNettingRun(newNettingRun(cycle.Frozen, since, report.Algorithm, tables, netted), store)
+	checkCriticalError(err)
+	err = keepNettingRuns(append(runs, cycle.Frozen), cfg.RunsKept, store)
+	checkCriticalError(err)
+	err = saveSettlements(instructions, store)
+	checkCriticalError(err)
+	err = saveCycle(cycle, store)
+	checkCriticalError(err)
+
+	// Stats of all claims together
+	before, after := getStats(mergeClasses(tables)), getStats(mergeClasses(netted))
+	event := nettingEvent{Before: before, After: after, Report: *report}
+	if err := this.emitEvent(eventNettingCompleted, event); err != nil {
+		return nil, err
+	}
+
+	bts, err := json.Marshal(report)
+	if err != nil {
+		log.Errorf("json.Marshal(report) error: %s", err.Error())
+		return nil, err
+	}
+
+	return bts, nil
+}
+// args: -
+func (this smartContract) invoke_Clear(store Store, args []string) ([]byte, error) {
+	return this.clearSmartContract(store)
+}
+// Pool is a class, the stats are of every class netted with it.
+// Risk metrics are added when the number of top exposures per counter party is given.
+// With a currency, the stats are of the pending settlement instructions in it instead,
+// as claims have no currency until they are netted and settled.
+// args: [Pool string, [Top int, [Currency string]]]
+func (this smartContract) query_Stats(store Store, args []string) ([]byte, error) {
+	message := fmt.Sprintf("queryStats called with args: %s\n", args)
+	log.Debugf(message)
+
+	// Check arguments
+	top := 0
+	if len(args) > 1 && args[1] != "" {
+		var err error
+		if top, err = strconv.Atoi(args[1]); err != nil || top < 1 {
+			log.Errorf(message)
+			return nil, errors.New(message)
+		}
+	}
+
+	// Load existing data
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+	nettingTable, err := loadPoolView(args, 0, store, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 2 && args[2] != "" {
+		currency, err := cfg.currency(args[2])
+		if err != nil {
+			return nil, err
+		}
+		pool, err := poolOf(args, 0, cfg)
+		if err != nil {
+			return nil, err
+		}
+		instructions, err := loadSettlements(store)
+		checkCriticalError(err)
+		nettingTable = pendingTable(emptyCopy(nettingTable), instructions, currency, pool, cfg)
+	}
+
+	stats := riskStats{NettingTableStats: getStats(nettingTable)}
+	if top > 0 {
+		stats.Risk = newRiskMetrics(nettingTable, top, cfg)
+	}
+
+	bts, err := json.Marshal(stats)
+	if err != nil {
+		log.Errorf("json.Marshal(stats) error: %s", err.Error())
+		return nil, err
+	}
+
+	return bts, nil
+}
+// args: [Class string]
+func (this smartContract) query_Graph(store Store, args []string) ([]byte, error) {
+	log.Debugf("queryGraph called with args: %s\n", args)
+
+	// Load existing data
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+	nettingTable, err := loadClassView(args, 0, store, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	bts, err := tableToBytes(nettingTable)
+	if err != nil {
+		return nil, err
+	}
+
+	return bts, nil
+}
+// args: CounterPartyId int, [Class string]
+func (this smartContract) query_Claims(store Store, args []string) ([]byte, error) {
+	message := fmt.Sprintf("queryClaims called with args: %s\n", args)
+	log.Debugf(message)
+
+	if len(args) < 1 {
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+
+	counterPartyId, err := strconv.Atoi(args[0])
+	if err != nil {
+		log.Errorf("strconv.Atoi(args[0]) error: %s", err.Error())
+		return nil, err
+	}
+
+	// Load existing data
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+	nettingTable, err := loadClassView(args, 1, store, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return nettingTable.GetClaims(counterPartyId), nil
+}
+
+func save(this Table, store Store) (error) {
+	return saveTable(this, storeKey, store)
+}
+
+func load(store Store) (Table, error) {
+	return loadTable(storeKey, store)
+}
+
+func saveTable(this Table, key string, store Store) (error) {
+	log.Debugf("Saving %s...\n", key)
+
+	// Data to Bytes
+	cfg, err := loadConfig(store)
+	if err != nil {
+		return err
+	}
+	bytes, err := encodeTable(this, cfg)
+	if err != nil {
+		return err
+	}
+	// Save Bytes
+	err = store.PutState(key, bytes)
+	if err != nil {
+		log.Errorf("store.PutState(key, bytes) error: %s", err.Error())
+		return err
+	}
+	log.Debugf("Saved data : %s\n", bytes)
+
+	return nil
+}
+
+func loadTable(key string, store Store) (Table, error) {
+	return loadClassTable(key, store, false)
+}
+
+// A gross table keeps opposite claims, see GrossTable
+func loadClassTable(key string, store Store, gross bool) (Table, error) {
+	log.Debugf("Loading %s...\n", key)
+
+	bytes, err := store.GetState(key)
+	if err != nil {
+		log.Errorf("store.GetState(key) error: %s", err.Error())
+		return nil, err
+	}
+
+	stored, err := decodeTable(bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// InitFromBytes would quietly drop or merge what does not fit. Claims both ways are
+	// those of a class that was gross before it could be offset, they are netted now.
+	if problems := checkTable(stored, true); len(problems) > 0 {
+		message := fmt.Sprintf("%s is inconsistent, see the Validate query: %s\n", key, problems[0])
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	return stored.toClassTable(gross), nil
+}
+
+type claim struct {
+	From  int     `json:"f"`
+	To    int     `json:"t"`
+	Value float64 `json:"v"`
+}
+
+// Same format the table is stored in
+type tableBytes struct {
+	Nodes []int
+	Edges []claim
+}
+
+type claimsByCounterParties []claim
+
+func (c claimsByCounterParties) Len() int      { return len(c) }
+func (c claimsByCounterParties) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c claimsByCounterParties) Less(i, j int) bool {
+	if c[i].From != c[j].From {
+		return c[i].From < c[j].From
+	}
+	return c[i].To < c[j].To
+}
+
+// args: From int, To int, Value float
+func parseClaim(args []string) (claim, error) {
+	from, err := strconv.Atoi(args[0])
+	if err != nil {
+		log.Errorf("strconv.Atoi(args[0]) error: %s", err.Error())
+		return claim{}, err
+	}
+	to, err := strconv.Atoi(args[1])
+	if err != nil {
+		log.Errorf("strconv.Atoi(args[1]) error: %s", err.Error())
+		return claim{}, err
+	}
+	value, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		log.Errorf("strconv.ParseFloat(args[2], 64) error: %s", err.Error())
+		return claim{}, err
+	}
+	return claim{From: from, To: to, Value: value}, nil
+}
+
+func getStats(this Table) (stats netting.NettingTableStats) {
+	switch table := this.(type) {
+	case *DenseTable:
+		return table.stats()
+	case *GrossTable:
+		return table.stats()
+	}
+	err := json.Unmarshal(this.GetStats(), &stats)
+	if err != nil {
+		log.Errorf("json.Unmarshal(this.GetStats()) error: %s", err.Error())
+	}
+	return
+}
+
+// Negative values are the claims of other counter parties on this one
+func getClaims(this Table, counterPartyId int) (claims []claim) {
+	err := json.Unmarshal(this.GetClaims(counterPartyId), &claims)
+	if err != nil {
+		log.Errorf("json.Unmarshal(this.GetClaims(%d)) error: %s", counterPartyId, err.Error())
+	}
+	return
+}
+
+// Counter parties are never removed, so IDs are 0..N-1.
+// Not from the stats, they copy the table and compare every pair, and fail on a single
+// counter party. The graph of a NettingTable is only seen through ToBytes, its edges are skipped.
+func counterParties(this Table) int {
+	switch table := this.(type) {
+	case *DenseTable:
+		return table.n
+	case *GrossTable:
+		return table.n
+	}
+	bytes, err := this.ToBytes()
+	if err != nil {
+		log.Errorf("this.ToBytes() error: %s", err.Error())
+		return 0
+	}
+	var nodes struct{ Nodes []int }
+	err = json.Unmarshal(bytes, &nodes)
+	if err != nil {
+		log.Errorf("json.Unmarshal(bytes, &nodes) error: %s", err.Error())
+		return 0
+	}
+	return len(nodes.Nodes)
+}
+
+// Counter parties are never removed, so IDs are 0..N-1
+func hasCounterParty(this Table, counterPartyId int) bool {
+	return counterPartyId >= 0 && counterPartyId < counterParties(this)
+}
+
+// Sorted by counter parties, so every peer gets the same order
+func getAllClaims(this Table) []claim {
+	switch table := this.(type) {
+	case *DenseTable:
+		return table.claims()
+	case *GrossTable:
+		return table.claims()
+	}
+	bytes, err := this.ToBytes()
+	if err != nil {
+		log.Errorf("this.ToBytes() error: %s", err.Error())
+		return []claim{}
+	}
+	var nodesAndEdges tableBytes
+	err = json.Unmarshal(bytes, &nodesAndEdges)
+	if err != nil {
+		log.Errorf("json.Unmarshal(bytes, &nodesAndEdges) error: %s", err.Error())
+		return []claim{}
+	}
+	sort.Sort(claimsByCounterParties(nodesAndEdges.Edges))
+	return nodesAndEdges.Edges
+}
@@ -0,0 +1,110 @@
+package contract
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"sort"
+)
+
+// Key-value state the smart contract is kept in.
+// On-chain it is the ledger, off-chain a MemoryStore or a FileStore.
+type Store interface {
+	// nil if there is no such key
+	GetState(key string) ([]byte, error)
+	PutState(key string, value []byte) error
+	DelState(key string) error
+	// Keys from startKey to endKey, both included as on the ledger, with their values
+	RangeQueryState(startKey string, endKey string) (map[string][]byte, error)
+	// Puts and deletes the changes of a transaction together, as far as the store can
+	Commit(writes map[string][]byte, deleted map[string]bool) error
+}
+
+// Puts, then deletes, each in the order of keys, so that stores see the same sequence every time
+func commitEach(store Store, writes map[string][]byte, deleted map[string]bool) error {
+	keys := []string{}
+	for key := range writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := store.PutState(key, writes[key]); err != nil {
+			log.Errorf("store.PutState(key, value) error: %s", err.Error())
+			return err
+		}
+	}
+
+	keys = []string{}
+	for key := range deleted {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := store.DelState(key); err != nil {
+			log.Errorf("store.DelState(key) error: %s", err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Every key with the prefix and its value. Keys are made of printable ASCII, '~' is the last one.
+func prefixQuery(store Store, prefix string) (map[string][]byte, error) {
+	entries, err := store.RangeQueryState(prefix, prefix+"~")
+	if err != nil {
+		log.Errorf("store.RangeQueryState(prefix) error: %s", err.Error())
+		return nil, err
+	}
+	return entries, nil
+}
+
+func sortedKeys(entries map[string][]byte) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Keeps the state on the ledger
+type StubStore struct {
+	stub shim.ChaincodeStubInterface
+}
+
+func NewStubStore(stub shim.ChaincodeStubInterface) *StubStore {
+	return &StubStore{stub: stub}
+}
+
+func (this *StubStore) GetState(key string) ([]byte, error) {
+	return this.stub.GetState(key)
+}
+
+func (this *StubStore) PutState(key string, value []byte) error {
+	return this.stub.PutState(key, value)
+}
+
+func (this *StubStore) DelState(key string) error {
+	return this.stub.DelState(key)
+}
+
+func (this *StubStore) RangeQueryState(startKey string, endKey string) (map[string][]byte, error) {
+	iterator, err := this.stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+	entries := map[string][]byte{}
+	for iterator.HasNext() {
+		key, value, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		entries[key] = value
+	}
+	return entries, nil
+}
+
+// The ledger keeps all the changes of a transaction or none of them
+func (this *StubStore) Commit(writes map[string][]byte, deleted map[string]bool) error {
+	return commitEach(this, writes, deleted)
+}
@@ -0,0 +1,184 @@
+package contract
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var storeTestCalls = [][]string{
+	{"AddCounterParty"},
+	{"AddCounterParty"},
+	{"AddCounterParty"},
+	{"AddClaim", "0", "1", "10"},
+	{"AddClaim", "1", "2", "10"},
+	{"AddClaim", "2", "0", "7.5", "gateway-1"},
+	{"AddClaim", "2", "0", "7.5", "gateway-1"},
+	{"CloseCycle"},
+	{"RunNetting", "EUR", "2016-09-30"},
+	{"ConfirmSettlement", "0", "1"},
+	{"ConfirmSettlement", "0", "0"},
+}
+
+var storeTestQueries = [][]string{
+	{"Stats"},
+	{"Claims", "0"},
+	{"Claims", "1"},
+	{"Settlements"},
+	{"Config"},
+}
+
+func checkServiceQuery(t *testing.T, service *Service, function string, args []string, value string) {
+	bytes, err := service.Query(function, args)
+	if err != nil {
+		fmt.Println("Service query", function, "failed", err)
+		t.FailNow()
+	}
+	if string(bytes) != value {
+		fmt.Println("Service query value", string(bytes), "was not", value, "as expected")
+		t.FailNow()
+	}
+}
+
+func TestService_SameAsChaincode(t *testing.T) {
+	log.Info("\n\nService off-chain test")
+	stub := NewMockCallerStub(new(Chaincode), "admin")
+	service := NewService(NewMemoryStore())
+	//calls
+	checkInit(t, stub, []string{})
+	if _, err := service.Init([]string{}); err != nil {
+		fmt.Println("Service init failed", err)
+		t.FailNow()
+	}
+	for _, call := range storeTestCalls {
+		checkInvoke(t, stub, call[0], call[1:])
+		if _, err := service.Invoke(call[0], call[1:]); err != nil {
+			fmt.Println("Service invoke", call, "failed", err)
+			t.FailNow()
+		}
+	}
+	for _, query := range storeTestQueries {
+		bytes, _ := stub.MockQuery(query[0], query[1:])
+		checkServiceQuery(t, service, query[0], query[1:], string(bytes))
+	}
+}
+
+func TestService_FailedInvoke(t *testing.T) {
+	log.Info("\n\nService failed invoke test")
+	store := NewMemoryStore()
+	service := NewService(store)
+	events := []string{}
+	service.Events = func(name string, payload []byte) error {
+		events = append(events, name)
+		return nil
+	}
+	service.Clock = func() time.Time {
+		return time.Date(2016, 9, 30, 17, 0, 0, 0, time.UTC)
+	}
+	//calls
+	service.Init([]string{})
+	service.Invoke("AddCounterParty", []string{})
+	service.Invoke("AddCounterParty", []string{})
+	service.Invoke("AddClaim", []string{"0", "1", "10"})
+	keys := fmt.Sprint(store.Keys())
+	if _, err := service.Invoke("CancelClaim", []string{"0", "1", "20"}); err == nil {
+		fmt.Println("Service invoke CancelClaim did not fail as expected")
+		t.FailNow()
+	}
+	if fmt.Sprint(store.Keys()) != keys || len(events) != 3 {
+		fmt.Println("Failed invoke changed", store.Keys(), "or emitted", events)
+		t.FailNow()
+	}
+
+	// Off-chain the transaction time is the clock
+	service.Invoke("CloseCycle", []string{})
+	checkServiceQuery(t, service, "Cycle", []string{},
+		"{\"open\":2,\"frozen\":1,\"cut_off\":\"2016-09-30T17:00:00Z\",\"netted\":false}")
+	service.Invoke("RunNetting", []string{})
+	checkServiceQuery(t, service, "Settlements", []string{}, "[" +
+		"{\"id\":0,\"payer\":1,\"payee\":0,\"amount\":10,\"currency\":\"USD\",\"value_date\":\"2016-09-30\",\"status\":\"pending\",\"confirmed_by_payer\":false,\"confirmed_by_payee\":false}]")
+}
+
+func TestFileStore(t *testing.T) {
+	log.Info("\n\nFile store test")
+	dir, err := ioutil.TempDir("", "netting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		fmt.Println("NewFileStore failed", err)
+		t.FailNow()
+	}
+	service := NewService(store)
+	service.Init([]string{})
+	for _, call := range storeTestCalls {
+		service.Invoke(call[0], call[1:])
+	}
+
+	// Everything is in the file
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		fmt.Println("NewFileStore failed to reopen", err)
+		t.FailNow()
+	}
+	for _, query := range storeTestQueries {
+		bytes, _ := service.Query(query[0], query[1:])
+		checkServiceQuery(t, NewService(reopened), query[0], query[1:], string(bytes))
+	}
+
+	// A transaction the file could not be written for changes nothing
+	claims, _ := service.Query("Claims", []string{"0"})
+	os.RemoveAll(dir)
+	if _, err := service.Invoke("AddClaim", []string{"0", "1", "5"}); err == nil {
+		fmt.Println("AddClaim without a directory for the file did not fail")
+		t.FailNow()
+	}
+	checkServiceQuery(t, service, "Claims", []string{"0"}, string(claims))
+	os.Mkdir(dir, 0700)
+	service.Invoke("AddClaim", []string{"0", "1", "5"})
+	reopened, _ = NewFileStore(path)
+	claims, _ = service.Query("Claims", []string{"0"})
+	checkServiceQuery(t, NewService(reopened), "Claims", []string{"0"}, string(claims))
+}
+
+func TestTxStore_RangeQuery(t *testing.T) {
+	log.Info("\n\nRange query test")
+	store := NewMemoryStore()
+	for _, key := range []string{"A.1", "A.2", "A.3", "A/", "B.1"} {
+		store.PutState(key, []byte(key))
+	}
+	tx := newTxStore(store)
+	tx.DelState("A.2")
+	tx.PutState("A.4", []byte("A.4"))
+	tx.PutState("B.2", []byte("B.2"))
+
+	// Both ends are included, changes of the transaction are there before it commits
+	for _, test := range []struct {
+		store    Store
+		expected string
+	}{{store, "[A.1 A.2 A.3]"}, {tx, "[A.1 A.3 A.4]"}} {
+		entries, err := prefixQuery(test.store, "A.")
+		if err != nil || fmt.Sprint(sortedKeys(entries)) != test.expected {
+			fmt.Println("Range query returned", sortedKeys(entries), err, "instead of", test.expected)
+			t.FailNow()
+		}
+		for key, value := range entries {
+			if string(value) != key {
+				fmt.Println("Range query returned", string(value), "for", key)
+				t.FailNow()
+			}
+		}
+	}
+	entries, _ := tx.RangeQueryState("A.3", "B.1")
+	if fmt.Sprint(sortedKeys(entries)) != "[A.3 A.4 A/ B.1]" {
+		fmt.Println("Range query returned", sortedKeys(entries))
+		t.FailNow()
+	}
+}
@@ -0,0 +1,192 @@
+package contract
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/VladimirStarostenkov/netting"
+	"math"
+)
+
+// Report algorithm of results computed off-chain
+const algorithmSubmitted string = "submitted"
+
+// Metrics a submitted netting result is judged by, smaller is better
+var metrics map[string]func(netting.NettingTableStats) float64 = map[string]func(netting.NettingTableStats) float64{
+	metricL1:     func(stats netting.NettingTableStats) float64 { return stats.MetricL1 },
+	metricL2:     func(stats netting.NettingTableStats) float64 { return stats.MetricL2 },
+	metricClaims: func(stats netting.NettingTableStats) float64 { return float64(stats.NumberOfClaims) },
+}
+
+const (
+	metricL1     string = "l1"
+	metricL2     string = "l2"
+	metricClaims string = "claims"
+)
+
+// Nets the frozen cycle with a result computed off-chain, e.g. by the same algorithms
+// run on a FileStore. It is only verified here, which is much cheaper than netting.
+// The result replaces the claims of the classes netted with the first one and goes back
+// to them like RunNetting's, claims of the other classes are settled as they are. Only the admin and the configured
+// operators may submit, the report names the submitter by the fingerprint of its certificate.
+// args: Graph json (as returned by the Graph query), [Currency string, [ValueDate string]]
+func (this smartContract) invoke_SubmitNettingResult(store Store, args []string) ([]byte, error) {
+	message := fmt.Sprintf("invokeSubmitNettingResult called with args: %s\n", args)
+	log.Debugf(message)
+
+	// Check arguments
+	if len(args) < 1 {
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	var submitted tableBytes
+	err := json.Unmarshal([]byte(args[0]), &submitted)
+	if err != nil {
+		log.Errorf("json.Unmarshal(args[0], &submitted) error: %s", err.Error())
+		return nil, err
+	}
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+	currency, valueDate, err := parseSettlementArgs(this.txTime, cfg, args[1:])
+	if err != nil {
+		return nil, err
+	}
+	submitter, err := this.checkOperator(store, cfg)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("netting result submitted by %s\n", submitter)
+
+	// Load existing data
+	tables, err := loadForNetting(store, cfg)
+	if err != nil {
+		return nil, err
+	}
+	pools := cfg.pools()
+	if len(pools) == 0 || pools[0][0] != 0 {
+		message := fmt.Sprintf("claims of class %s are not netted\n", cfg.Classes[0])
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	pooled := emptyCopy(tables[0])
+	netted := append([]Table{}, tables...)
+	for _, class := range pools[0] {
+		mergeClaims(pooled, tables[class])
+		netted[class] = emptyCopy(tables[class])
+	}
+
+	result, err := verifyNettingResult(pooled, &submitted, cfg)
+	if err != nil {
+		return nil, err
+	}
+	for i, table := range splitPool(result, tables, pools[0]) {
+		netted[pools[0][i]] = table
+	}
+	report := &nettingReport{Submitter: submitter}
+	report.fillClasses(algorithmSubmitted, tables, netted, cfg, nil)
+
+	return this.completeNetting(store, tables, netted, report, currency, valueDate)
+}
+
+// The fingerprint of the certificate the caller has signed with, the admin's or an operator's.
+// Off-chain there are no callers, the fingerprint is empty then.
+func (this smartContract) checkOperator(store Store, cfg *config) (string, error) {
+	if this.signedBy == nil {
+		return "", nil
+	}
+	admin, err := store.GetState(adminKey)
+	if err != nil {
+		log.Errorf("store.GetState(adminKey) error: %s", err.Error())
+		return "", err
+	}
+	for _, certificate := range append([][]byte{admin}, cfg.Operators...) {
+		ok, err := this.signedBy(certificate)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return fingerprint(certificate), nil
+		}
+	}
+	message := "only the admin and operators may submit netting results\n"
+	log.Errorf(message)
+	return "", errors.New(message)
+}
+
+// Hex SHA-256 of a certificate
+func fingerprint(certificate []byte) string {
+	sum := sha256.Sum256(certificate)
+	return hex.EncodeToString(sum[:])
+}
+
+// Checks that the submitted claims may replace the table and makes a table of them.
+// The claims are checked in linear time, the metric takes getStats, which is quadratic
+// in the number of counter parties.
+func verifyNettingResult(this Table, submitted *tableBytes, cfg *config) (Table, error) {
+	fail := func(format string, a ...interface{}) (Table, error) {
+		message := fmt.Sprintf("netting result rejected: "+format+"\n", a...)
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+
+	// Same counter parties
+	N := counterParties(this)
+	if len(submitted.Nodes) != N {
+		return fail("%d counter parties instead of %d", len(submitted.Nodes), N)
+	}
+	seen := make([]bool, N)
+	for _, id := range submitted.Nodes {
+		if id < 0 || id >= N || seen[id] {
+			return fail("counter party %d is unknown or repeated", id)
+		}
+		seen[id] = true
+	}
+
+	// Positive claims between different counter parties, one per pair
+	original := map[[2]int]float64{}
+	for _, c := range getAllClaims(this) {
+		original[[2]int{c.From, c.To}] = c.Value
+	}
+	keepsClaims := netters[cfg.Algorithm].KeepsClaims()
+	pairs := map[[2]int]bool{}
+	result := emptyCopy(this)
+	for _, c := range submitted.Edges {
+		if c.From < 0 || c.From >= N || c.To < 0 || c.To >= N || c.From == c.To {
+			return fail("claim %d -> %d is not between known counter parties", c.From, c.To)
+		}
+		if math.IsNaN(c.Value) || math.IsInf(c.Value, 0) || c.Value <= 0.0 || cfg.isZero(cfg.round(c.Value)) {
+			return fail("claim %d -> %d of %v is not positive", c.From, c.To, c.Value)
+		}
+		if pairs[[2]int{c.From, c.To}] || pairs[[2]int{c.To, c.From}] {
+			return fail("more than one claim between %d and %d", c.From, c.To)
+		}
+		pairs[[2]int{c.From, c.To}] = true
+
+		// Algorithms that keep claims can only make them smaller
+		if keepsClaims && cfg.round(c.Value) > original[[2]int{c.From, c.To}] {
+			return fail("claim %d -> %d of %v is larger than the original %v",
+				c.From, c.To, c.Value, original[[2]int{c.From, c.To}])
+		}
+
+		result.AddClaim(c.From, c.To, cfg.round(c.Value))
+	}
+
+	// Nobody owes more or less than before
+	before := netPositions(this)
+	after := netPositions(result)
+	for id := range before {
+		if !cfg.isZero(cfg.round(after[id] - before[id])) {
+			return fail("net position of %d is %v instead of %v", id, after[id], before[id])
+		}
+	}
+
+	// And it is worth it
+	metric := metrics[cfg.Metric]
+	if metric(getStats(result)) >= metric(getStats(this)) {
+		return fail("%s metric is not improved", cfg.Metric)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,303 @@
+package contract
+
+import (
+	"encoding/json"
+	"github.com/VladimirStarostenkov/netting"
+	"github.com/gonum/graph/simple"
+	"math"
+	"sort"
+)
+
+// The netting table API the smart contract works with. *netting.NettingTable keeps claims
+// in a map based graph, which is fine for sparse tables, *DenseTable in a matrix.
+// *GrossTable keeps the claims of a class that may not be offset.
+type Table interface {
+	AddCounterParty() (CounterPartyID int)
+	// Opposite claims are netted, except by GrossTable. Claims on self,
+	// on unknown counter parties and of values not above 0 are ignored
+	AddClaim(SrcCounterPartyID int, DstCounterPartyID int, Value float64)
+	// Negative values are the claims of other counter parties on this one
+	GetClaims(CounterPartyID int) []byte
+	GetStats() []byte
+	ToBytes() ([]byte, error)
+}
+
+// Tables loaded with at least this many counter parties and this share
+// of the pairs having claims between them are dense
+const (
+	denseMinCounterParties int     = 50
+	denseMinDensity        float64 = 0.1
+)
+
+func isDense(N int, claims int) bool {
+	pairs := float64(N) * float64(N-1) / 2.0
+	return N >= denseMinCounterParties && float64(claims) >= denseMinDensity*pairs
+}
+
+// A table of N counter parties without claims, dense or not
+func newTable(N int, dense bool) Table {
+	if dense {
+		return NewDenseTable(N)
+	}
+	result := netting.NettingTable{}
+	result.Init()
+	for i := 0; i < N; i++ {
+		result.AddCounterParty()
+	}
+	return &result
+}
+
+// A table of N counter parties for a class, gross if the class may not be offset
+func newClassTable(N int, gross bool) Table {
+	if gross {
+		return NewGrossTable(N)
+	}
+	return newTable(N, false)
+}
+
+// Claims in an N×N matrix, 0 where there are none.
+// Lookups are array accesses rather than map ones, but a table takes N² floats.
+type DenseTable struct {
+	matrix *simple.DirectedMatrix
+	n      int
+}
+
+func NewDenseTable(N int) *DenseTable {
+	return &DenseTable{matrix: simple.NewDirectedMatrix(N, 0.0, 0.0, 0.0), n: N}
+}
+
+func (this *DenseTable) at(from, to int) float64 {
+	w, _ := this.matrix.Weight(simple.Node(from), simple.Node(to))
+	return w
+}
+
+func (this *DenseTable) set(from, to int, value float64) {
+	this.matrix.SetEdge(simple.Edge{F: simple.Node(from), T: simple.Node(to), W: value})
+}
+
+// The matrix does not grow, so it is copied into a larger one
+func (this *DenseTable) AddCounterParty() (CounterPartyID int) {
+	grown := NewDenseTable(this.n + 1)
+	for i := 0; i < this.n; i++ {
+		for j := 0; j < this.n; j++ {
+			if w := this.at(i, j); w != 0.0 {
+				grown.set(i, j, w)
+			}
+		}
+	}
+	*this = *grown
+	return this.n - 1
+}
+
+// Same as NettingTable.AddClaim
+func (this *DenseTable) AddClaim(SrcCounterPartyID int, DstCounterPartyID int, Value float64) {
+	from, to := SrcCounterPartyID, DstCounterPartyID
+	if from == to || from < 0 || from >= this.n || to < 0 || to >= this.n || !(Value > 0) {
+		return
+	}
+	Value += this.at(from, to) - this.at(to, from)
+	this.set(from, to, 0.0)
+	this.set(to, from, 0.0)
+	if Value > 0 {
+		this.set(from, to, Value)
+	} else if Value < 0 {
+		this.set(to, from, -Value)
+	}
+}
+
+// In the order of the other counter parties
+func (this *DenseTable) GetClaims(CounterPartyID int) []byte {
+	claims := []claim{}
+	if CounterPartyID >= 0 && CounterPartyID < this.n {
+		for to := 0; to < this.n; to++ {
+			if w := this.at(CounterPartyID, to) - this.at(to, CounterPartyID); w != 0.0 {
+				claims = append(claims, claim{From: CounterPartyID, To: to, Value: w})
+			}
+		}
+	}
+
+	result, err := json.Marshal(claims)
+	if err != nil {
+		return []byte{}
+	}
+	return result
+}
+
+func (this *DenseTable) GetStats() []byte {
+	result, err := json.Marshal(this.stats())
+	if err != nil {
+		return []byte{}
+	}
+	return result
+}
+
+// Same formulas and order of summation as NettingTable.GetStats,
+// where a claim on a counter party is a negative claim of it
+func (this *DenseTable) stats() netting.NettingTableStats {
+	N := this.n
+	w := func(i, j int) float64 {
+		return this.at(i, j) - this.at(j, i)
+	}
+
+	stats := netting.NettingTableStats{NumberOfCounterParties: N, MetricL1: -1.0, MetricL2: -1.0}
+	cAbsSum, cQuadSum := 0.0, 0.0
+	for i := 0; i < N; i++ {
+		for j := i + 1; j < N; j++ {
+			v := w(i, j)
+			if v != 0.0 {
+				stats.NumberOfClaims++
+			}
+			cAbsSum += math.Abs(v)
+			cQuadSum += math.Pow(v, 2)
+		}
+	}
+	if N > 0 {
+		stats.MetricL1 = cAbsSum / float64(N*(N-1)) * 2.0
+		stats.MetricL2 = math.Sqrt(cQuadSum / float64(N*(N-1)) * 2.0)
+	}
+	for j := 0; j < N; j++ {
+		h := 0.0
+		for i := 0; i < N; i++ {
+			h += w(j, i)
+		}
+		stats.SumH += h
+	}
+	return stats
+}
+
+func (this *DenseTable) claims() []claim {
+	claims := []claim{}
+	for i := 0; i < this.n; i++ {
+		for j := 0; j < this.n; j++ {
+			if v := this.at(i, j); v != 0.0 {
+				claims = append(claims, claim{From: i, To: j, Value: v})
+			}
+		}
+	}
+	return claims
+}
+
+// Same format as NettingTable.ToBytes
+func (this *DenseTable) ToBytes() ([]byte, error) {
+	nodes := make([]int, this.n)
+	for i := range nodes {
+		nodes[i] = i
+	}
+	return json.Marshal(tableBytes{Nodes: nodes, Edges: this.claims()})
+}
+
+// Claims of a class that may not be offset. Opposite claims are both kept, as both
+// have to be paid, claims of the same direction add up.
+type GrossTable struct {
+	values map[[2]int]float64
+	n      int
+}
+
+func NewGrossTable(N int) *GrossTable {
+	return &GrossTable{values: map[[2]int]float64{}, n: N}
+}
+
+func (this *GrossTable) AddCounterParty() (CounterPartyID int) {
+	this.n++
+	return this.n - 1
+}
+
+// Same as NettingTable.AddClaim, but the opposite claim is left as it is
+func (this *GrossTable) AddClaim(SrcCounterPartyID int, DstCounterPartyID int, Value float64) {
+	from, to := SrcCounterPartyID, DstCounterPartyID
+	if from == to || from < 0 || from >= this.n || to < 0 || to >= this.n || !(Value > 0) {
+		return
+	}
+	this.values[[2]int{from, to}] += Value
+}
+
+// Takes the amount off the claim, what is not there is ignored
+func (this *GrossTable) reduceClaim(from int, to int, value float64) {
+	key := [2]int{from, to}
+	if remaining := this.values[key] - value; remaining > 0 {
+		this.values[key] = remaining
+	} else {
+		delete(this.values, key)
+	}
+}
+
+// In the order of the other counter parties, the claim of this one first
+func (this *GrossTable) GetClaims(CounterPartyID int) []byte {
+	claims := []claim{}
+	if CounterPartyID >= 0 && CounterPartyID < this.n {
+		for _, c := range this.claims() {
+			if c.From == CounterPartyID {
+				claims = append(claims, c)
+			} else if c.To == CounterPartyID {
+				claims = append(claims, claim{From: CounterPartyID, To: c.From, Value: -c.Value})
+			}
+		}
+		sort.Stable(claimsByCounterParties(claims))
+	}
+
+	result, err := json.Marshal(claims)
+	if err != nil {
+		return []byte{}
+	}
+	return result
+}
+
+func (this *GrossTable) GetStats() []byte {
+	result, err := json.Marshal(this.stats())
+	if err != nil {
+		return []byte{}
+	}
+	return result
+}
+
+// Same formulas as DenseTable.stats, with every claim on its own rather than a weight per pair
+func (this *GrossTable) stats() netting.NettingTableStats {
+	N := this.n
+	claims := this.claims()
+
+	stats := netting.NettingTableStats{NumberOfCounterParties: N, NumberOfClaims: len(claims), MetricL1: -1.0, MetricL2: -1.0}
+	cAbsSum, cQuadSum := 0.0, 0.0
+	h := make([]float64, N)
+	for _, c := range claims {
+		cAbsSum += c.Value
+		cQuadSum += math.Pow(c.Value, 2)
+		h[c.From] += c.Value
+		h[c.To] -= c.Value
+	}
+	if N > 1 {
+		stats.MetricL1 = cAbsSum / float64(N*(N-1)) * 2.0
+		stats.MetricL2 = math.Sqrt(cQuadSum / float64(N*(N-1)) * 2.0)
+	}
+	for j := 0; j < N; j++ {
+		stats.SumH += h[j]
+	}
+	return stats
+}
+
+// Sorted by counter parties
+func (this *GrossTable) claims() []claim {
+	claims := []claim{}
+	for key, value := range this.values {
+		claims = append(claims, claim{From: key[0], To: key[1], Value: value})
+	}
+	sort.Sort(claimsByCounterParties(claims))
+	return claims
+}
+
+// Same format as NettingTable.ToBytes
+func (this *GrossTable) ToBytes() ([]byte, error) {
+	nodes := make([]int, this.n)
+	for i := range nodes {
+		nodes[i] = i
+	}
+	return json.Marshal(tableBytes{Nodes: nodes, Edges: this.claims()})
+}
+
+// Takes a paid or cancelled claim off the table. Other tables net it with an opposite claim.
+func reduceClaim(this Table, from int, to int, value float64) {
+	if gross, ok := this.(*GrossTable); ok {
+		gross.reduceClaim(from, to, value)
+		return
+	}
+	this.AddClaim(to, from, value)
+}
@@ -0,0 +1,144 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// Random claims between N counter parties, every pair has one with the given probability
+func randomTable(N int, density float64, dense bool) Table {
+	random := rand.New(rand.NewSource(42))
+	table := newTable(N, dense)
+	for i := 0; i < N; i++ {
+		for j := i + 1; j < N; j++ {
+			if random.Float64() < density {
+				from, to := i, j
+				if random.Intn(2) == 0 {
+					from, to = j, i
+				}
+				table.AddClaim(from, to, float64(random.Intn(10000))/100.0+0.01)
+			}
+		}
+	}
+	return table
+}
+
+func sortedClaims(bytes []byte) string {
+	var claims []claim
+	json.Unmarshal(bytes, &claims)
+	sort.Sort(claimsByCounterParties(claims))
+	return fmt.Sprint(claims)
+}
+
+func TestDenseTable_SameAsNettingTable(t *testing.T) {
+	log.Info("\n\nDense table test")
+	sparse, dense := referenceTable(), NewDenseTable(0)
+	for i := 0; i < 10; i++ {
+		dense.AddCounterParty()
+	}
+	for _, c := range referenceClaims {
+		dense.AddClaim(c.From, c.To, c.Value)
+	}
+	// Opposite, self, unknown and negative claims
+	for _, c := range []claim{{4, 3, 55}, {3, 4, 200}, {2, 2, 5}, {1, 10, 5}, {1, 2, -5}} {
+		sparse.AddClaim(c.From, c.To, c.Value)
+		dense.AddClaim(c.From, c.To, c.Value)
+	}
+
+	if string(dense.GetStats()) != string(sparse.GetStats()) {
+		fmt.Println("Dense stats", string(dense.GetStats()), "instead of", string(sparse.GetStats()))
+		t.FailNow()
+	}
+	if fmt.Sprint(getAllClaims(dense)) != fmt.Sprint(getAllClaims(sparse)) {
+		fmt.Println("Dense claims", getAllClaims(dense), "instead of", getAllClaims(sparse))
+		t.FailNow()
+	}
+	for id := 0; id < 10; id++ {
+		if sortedClaims(dense.GetClaims(id)) != sortedClaims(sparse.GetClaims(id)) {
+			fmt.Println("Dense claims of", id, string(dense.GetClaims(id)), "instead of", string(sparse.GetClaims(id)))
+			t.FailNow()
+		}
+	}
+
+	// Netting keeps the kind of table
+	cfg := defaultConfig()
+	sparseResult, _, _ := optimize(sparse, cfg, algorithmCycles, nil)
+	denseResult, _, _ := optimize(dense, cfg, algorithmCycles, nil)
+	if _, ok := denseResult.(*DenseTable); !ok || string(denseResult.GetStats()) != string(sparseResult.GetStats()) {
+		fmt.Println("Dense netting", string(denseResult.GetStats()), "instead of", string(sparseResult.GetStats()))
+		t.FailNow()
+	}
+}
+
+// Without the stats, which fail on a single counter party
+func TestTable_CounterParties(t *testing.T) {
+	log.Info("\n\nTable counter parties test")
+	for _, N := range []int{0, 1, 10} {
+		for _, table := range []Table{newTable(N, false), newTable(N, true)} {
+			if counterParties(table) != N || hasCounterParty(table, N) || N > 0 && !hasCounterParty(table, N-1) {
+				fmt.Println("Table of", N, "counter parties has", counterParties(table))
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestDenseTable_ChosenByDensity(t *testing.T) {
+	log.Info("\n\nDense table choice test")
+	for _, c := range []struct {
+		N       int
+		density float64
+		dense   bool
+	}{{10, 1.0, false}, {60, 0.01, false}, {60, 0.5, true}} {
+		bytes, _ := randomTable(c.N, c.density, false).ToBytes()
+		stored, _ := decodeTable(bytes)
+		if _, dense := stored.toTable().(*DenseTable); dense != c.dense {
+			fmt.Println("Table of", c.N, "counter parties and density", c.density, "dense", dense)
+			t.FailNow()
+		}
+	}
+}
+
+// 500 counter parties, half of the pairs with claims
+func benchmarkStats(b *testing.B, dense bool) {
+	table := randomTable(500, 0.5, dense)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		getStats(table)
+	}
+}
+
+func BenchmarkStats_NettingTable(b *testing.B) { benchmarkStats(b, false) }
+func BenchmarkStats_DenseTable(b *testing.B)   { benchmarkStats(b, true) }
+
+// What loading a table takes once it is decoded
+func benchmarkLoad(b *testing.B, dense bool) {
+	claims := getAllClaims(randomTable(500, 0.5, false))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		table := newTable(500, dense)
+		for _, c := range claims {
+			table.AddClaim(c.From, c.To, c.Value)
+		}
+	}
+}
+
+func BenchmarkLoad_NettingTable(b *testing.B) { benchmarkLoad(b, false) }
+func BenchmarkLoad_DenseTable(b *testing.B)   { benchmarkLoad(b, true) }
+
+func benchmarkNetting(b *testing.B, dense bool) {
+	table := randomTable(500, 0.5, dense)
+	cfg := defaultConfig()
+	cfg.MaxCycleLength = 4
+	cfg.CycleBudget = 100000
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		optimize(table, cfg, algorithmCycles, nil)
+	}
+}
+
+func BenchmarkNetting_NettingTable(b *testing.B) { benchmarkNetting(b, false) }
+func BenchmarkNetting_DenseTable(b *testing.B)   { benchmarkNetting(b, true) }
@@ -0,0 +1,451 @@
+package contract
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Claims before and after every netting run are kept under this prefix and the cycle netted,
+// as many runs as configured
+const nettingRunKeyPrefix string = "NettingRun."
+
+// Cycles of the kept runs, oldest first
+const nettingRunsKey string = "NettingRuns"
+
+// Claims as they were submitted are kept under this prefix, the cycle they went into,
+// the time and the binding of their transaction: one key per claim
+const claimRecordsKeyPrefix string = "ClaimRecords."
+
+// An applied claim, a cancellation has a negative value
+type claimRecord struct {
+	claim
+	// Empty for the first class
+	Class string `json:"class,omitempty"`
+	Key   string `json:"key,omitempty"`
+	Cycle int    `json:"cycle"`
+}
+
+// Claims of every class, a pair may have a claim of each class
+type nettingRun struct {
+	Cycle int `json:"cycle"`
+	// Cycle of the run before, claims of the cycles after it went into this one
+	Since     int     `json:"since"`
+	Algorithm string  `json:"algorithm"`
+	Before    []claim `json:"before"`
+	After     []claim `json:"after"`
+}
+
+// A claim between two counter parties before and after netting,
+// "f" is the creditor before netting, or after it if there was no claim
+type tracedClaim struct {
+	From   int     `json:"f"`
+	To     int     `json:"t"`
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+	// Before is what the claims submitted since the last netting run add up to,
+	// together with what was carried over from it
+	Submitted []claimRecord `json:"submitted"`
+	Carried   float64       `json:"carried"`
+}
+
+// Claims changed together by netting. Netting keeps net positions, so what it changes
+// adds up to cycles. A cancelled cycle only reduces claims, otherwise obligations
+// were moved from one path of claims to another.
+type traceContribution struct {
+	// A claim of every counter party on the next one has grown, or one of the next on it has shrunk
+	CounterParties []int         `json:"counter_parties"`
+	Amount         float64       `json:"amount"`
+	Cancelled      bool          `json:"cancelled"`
+	Claims         []tracedClaim `json:"claims"`
+}
+
+// Returned by the Trace query
+type obligationTrace struct {
+	Cycle         int                 `json:"cycle"`
+	Algorithm     string              `json:"algorithm"`
+	Claim         tracedClaim         `json:"claim"`
+	Contributions []traceContribution `json:"contributions"`
+}
+
+// Explains how netting came to the claim between two counter parties:
+// the cycles of claims it has changed together with this one,
+// and the submitted claims every claim of them was made of.
+// args: CounterPartyId int, CounterPartyId int, [Cycle int], the last netted one by default
+func (this smartContract) query_Trace(store Store, args []string) ([]byte, error) {
+	message := fmt.Sprintf("queryTrace called with args: %s\n", args)
+	log.Debugf(message)
+
+	// Check arguments
+	if len(args) < 2 {
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	a, errA := strconv.Atoi(args[0])
+	b, errB := strconv.Atoi(args[1])
+	if errA != nil || errB != nil || a == b {
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+
+	// Load existing data
+	runs, err := loadNettingRuns(store)
+	checkCriticalError(err)
+	cycle := -1
+	if len(args) > 2 && args[2] != "" {
+		if cycle, err = strconv.Atoi(args[2]); err != nil {
+			log.Errorf("strconv.Atoi(args[2]) error: %s", err.Error())
+			return nil, err
+		}
+	} else if len(runs) > 0 {
+		cycle = runs[len(runs)-1]
+	}
+	kept := false
+	for _, c := range runs {
+		kept = kept || c == cycle
+	}
+	if !kept {
+		message := fmt.Sprintf("cycle %d was not netted or its run is no longer kept\n", cycle)
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+	run, err := loadNettingRun(cycle, store)
+	checkCriticalError(err)
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+
+	before, after := signedWeights(run.Before), signedWeights(run.After)
+	if before[a] == nil && after[a] == nil || before[b] == nil && after[b] == nil {
+		message := fmt.Sprintf("no claims of %d or %d in cycle %d\n", a, b, cycle)
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+
+	records := []claimRecord{}
+	for c := run.Since + 1; c <= cycle; c++ {
+		cycleRecords, err := loadClaimRecords(c, store)
+		checkCriticalError(err)
+		records = append(records, cycleRecords...)
+	}
+
+	trace := obligationTrace{Cycle: run.Cycle, Algorithm: run.Algorithm,
+		Claim: traceClaim(a, b, before, after), Contributions: traceContributions(a, b, before, after, cfg)}
+	trace.Claim.addRecords(records, cfg)
+	for i := range trace.Contributions {
+		for j := range trace.Contributions[i].Claims {
+			trace.Contributions[i].Claims[j].addRecords(records, cfg)
+		}
+	}
+
+	bts, err := json.Marshal(trace)
+	if err != nil {
+		log.Errorf("json.Marshal(trace) error: %s", err.Error())
+		return nil, err
+	}
+
+	return bts, nil
+}
+
+// By counter parties: positive - a claim of the first one on the second, negative - the opposite.
+// Claims of different classes add up.
+func signedWeights(claims []claim) map[int]map[int]float64 {
+	weights := map[int]map[int]float64{}
+	add := func(from, to int, value float64) {
+		if weights[from] == nil {
+			weights[from] = map[int]float64{}
+		}
+		weights[from][to] += value
+	}
+	for _, c := range claims {
+		add(c.From, c.To, c.Value)
+		add(c.To, c.From, -c.Value)
+	}
+	return weights
+}
+
+func traceClaim(from, to int, before, after map[int]map[int]float64) tracedClaim {
+	if before[from][to] < 0.0 || before[from][to] == 0.0 && after[from][to] < 0.0 {
+		from, to = to, from
+	}
+	return tracedClaim{From: from, To: to, Before: before[from][to], After: after[from][to]}
+}
+
+// Claims between the two counter parties in either direction
+func (this *tracedClaim) addRecords(records []claimRecord, cfg *config) {
+	this.Submitted = []claimRecord{}
+	submitted := 0.0
+	for _, record := range records {
+		if record.From == this.From && record.To == this.To {
+			submitted += record.Value
+		} else if record.From == this.To && record.To == this.From {
+			submitted -= record.Value
+		} else {
+			continue
+		}
+		this.Submitted = append(this.Submitted, record)
+	}
+	this.Carried = cfg.round(this.Before - submitted)
+}
+
+// What netting has changed is a circulation, as net positions are kept. The part of it
+// through the pair is split into cycles, each found by a breadth first search.
+func traceContributions(a, b int, before, after map[int]map[int]float64, cfg *config) []traceContribution {
+	const epsilon = 1e-9
+
+	// Flow of the change, along the edges it is positive on
+	flow := map[int]map[int]float64{}
+	for from, row := range before {
+		for to := range row {
+			if change := after[from][to] - before[from][to]; change > epsilon {
+				if flow[from] == nil {
+					flow[from] = map[int]float64{}
+				}
+				flow[from][to] = change
+			}
+		}
+	}
+	for from, row := range after {
+		for to, weight := range row {
+			if _, ok := before[from][to]; !ok && weight > epsilon {
+				if flow[from] == nil {
+					flow[from] = map[int]float64{}
+				}
+				flow[from][to] = weight
+			}
+		}
+	}
+	if flow[a][b] == 0.0 {
+		a, b = b, a
+	}
+
+	contributions := []traceContribution{}
+	for flow[a][b] > epsilon {
+		path := flowPath(flow, b, a)
+		if path == nil {
+			// Only rounding is left
+			break
+		}
+		cycle := append([]int{a}, path...)
+		cycle = cycle[:len(cycle)-1]
+		amount := flow[a][b]
+		for i := range cycle {
+			amount = math.Min(amount, flow[cycle[i]][cycle[(i+1)%len(cycle)]])
+		}
+
+		contribution := traceContribution{CounterParties: cycle, Amount: cfg.round(amount), Cancelled: true}
+		for i := range cycle {
+			from, to := cycle[i], cycle[(i+1)%len(cycle)]
+			flow[from][to] -= amount
+			traced := traceClaim(from, to, before, after)
+			traced.Before, traced.After = cfg.round(traced.Before), cfg.round(traced.After)
+			// Cancelling only takes off claims
+			contribution.Cancelled = contribution.Cancelled && traced.After < traced.Before
+			contribution.Claims = append(contribution.Claims, traced)
+		}
+		contributions = append(contributions, contribution)
+	}
+	return contributions
+}
+
+// Shortest path of positive flow, counter parties in the order of IDs, nil if there is none
+func flowPath(flow map[int]map[int]float64, from int, to int) []int {
+	const epsilon = 1e-9
+
+	previous := map[int]int{from: from}
+	queue := []int{from}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		next := []int{}
+		for id, amount := range flow[node] {
+			if amount > epsilon {
+				next = append(next, id)
+			}
+		}
+		sort.Ints(next)
+		for _, id := range next {
+			if _, seen := previous[id]; seen {
+				continue
+			}
+			previous[id] = node
+			if id == to {
+				path := []int{to}
+				for path[0] != from {
+					path = append([]int{previous[path[0]]}, path...)
+				}
+				return path
+			}
+			queue = append(queue, id)
+		}
+	}
+	return nil
+}
+
+func newNettingRun(cycle int, since int, algorithm string, before []Table, after []Table) *nettingRun {
+	run := &nettingRun{Cycle: cycle, Since: since, Algorithm: algorithm, Before: []claim{}, After: []claim{}}
+	for class := range before {
+		run.Before = append(run.Before, getAllClaims(before[class])...)
+		run.After = append(run.After, getAllClaims(after[class])...)
+	}
+	return run
+}
+
+func saveNettingRun(run *nettingRun, store Store) error {
+	bytes, err := json.Marshal(run)
+	if err != nil {
+		log.Errorf("json.Marshal(run) error: %s", err.Error())
+		return err
+	}
+	err = store.PutState(nettingRunKeyPrefix+strconv.Itoa(run.Cycle), bytes)
+	if err != nil {
+		log.Errorf("store.PutState(nettingRunKeyPrefix+cycle, bytes) error: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+func loadNettingRun(cycle int, store Store) (*nettingRun, error) {
+	bytes, err := store.GetState(nettingRunKeyPrefix + strconv.Itoa(cycle))
+	if err != nil {
+		log.Errorf("store.GetState(nettingRunKeyPrefix+cycle) error: %s", err.Error())
+		return nil, err
+	}
+	var run nettingRun
+	err = json.Unmarshal(bytes, &run)
+	if err != nil {
+		log.Errorf("json.Unmarshal(bytes, &run) error: %s", err.Error())
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Kept runs and the claims they were made of: the oldest ones go until there are as many as kept
+func keepNettingRuns(runs []int, kept int, store Store) error {
+	for len(runs) > kept {
+		if err := dropNettingRun(runs[0], store); err != nil {
+			return err
+		}
+		runs = runs[1:]
+	}
+	return saveNettingRuns(runs, store)
+}
+
+func dropNettingRun(cycle int, store Store) error {
+	run, err := loadNettingRun(cycle, store)
+	if err != nil {
+		return err
+	}
+	for c := run.Since + 1; c <= cycle; c++ {
+		records, err := prefixQuery(store, claimRecordsCycleKey(c))
+		if err != nil {
+			return err
+		}
+		for _, key := range sortedKeys(records) {
+			if err := store.DelState(key); err != nil {
+				log.Errorf("store.DelState(key) error: %s", err.Error())
+				return err
+			}
+		}
+	}
+	err = store.DelState(nettingRunKeyPrefix + strconv.Itoa(cycle))
+	if err != nil {
+		log.Errorf("store.DelState(nettingRunKeyPrefix+cycle) error: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+func saveNettingRuns(runs []int, store Store) error {
+	bytes, err := json.Marshal(runs)
+	if err != nil {
+		log.Errorf("json.Marshal(runs) error: %s", err.Error())
+		return err
+	}
+	err = store.PutState(nettingRunsKey, bytes)
+	if err != nil {
+		log.Errorf("store.PutState(nettingRunsKey, bytes) error: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+func loadNettingRuns(store Store) ([]int, error) {
+	bytes, err := store.GetState(nettingRunsKey)
+	if err != nil {
+		log.Errorf("store.GetState(nettingRunsKey) error: %s", err.Error())
+		return nil, err
+	}
+	runs := []int{}
+	if len(bytes) == 0 {
+		return runs, nil
+	}
+	err = json.Unmarshal(bytes, &runs)
+	if err != nil {
+		log.Errorf("json.Unmarshal(bytes, &runs) error: %s", err.Error())
+		return nil, err
+	}
+	return runs, nil
+}
+
+// Prefix of the records of a cycle, which is not a prefix of those of any other one
+func claimRecordsCycleKey(cycle int) string {
+	return claimRecordsKeyPrefix + strconv.Itoa(cycle) + "."
+}
+
+// Every applied claim is recorded under a key of its own in the open cycle, so transactions
+// write different keys. Keys are sorted by the transaction time, which is zero when unknown.
+func (this smartContract) addClaimRecord(record claimRecord, store Store) error {
+	nanos := int64(0)
+	if !this.txTime.IsZero() {
+		nanos = this.txTime.UnixNano()
+	}
+	bytes, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("json.Marshal(record) error: %s", err.Error())
+		return err
+	}
+
+	// Off-chain and in tests transactions may have the same time and binding, a number tells them apart
+	base := fmt.Sprintf("%s%020d.%s", claimRecordsCycleKey(record.Cycle), nanos, this.txID)
+	key := base
+	for n := 1; ; n++ {
+		existing, err := store.GetState(key)
+		if err != nil {
+			log.Errorf("store.GetState(key) error: %s", err.Error())
+			return err
+		}
+		if existing == nil {
+			break
+		}
+		key = fmt.Sprintf("%s.%09d", base, n)
+	}
+
+	err = store.PutState(key, bytes)
+	if err != nil {
+		log.Errorf("store.PutState(key, bytes) error: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// In the order of their keys
+func loadClaimRecords(cycle int, store Store) ([]claimRecord, error) {
+	entries, err := prefixQuery(store, claimRecordsCycleKey(cycle))
+	if err != nil {
+		return nil, err
+	}
+	records := []claimRecord{}
+	for _, key := range sortedKeys(entries) {
+		var record claimRecord
+		err = json.Unmarshal(entries[key], &record)
+		if err != nil {
+			log.Errorf("json.Unmarshal(bytes, &record) error: %s", err.Error())
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,235 @@
+package contract
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Returned by Validate and Repair
+type stateProblem struct {
+	Key     string `json:"key"`
+	Problem string `json:"problem"`
+}
+
+// Tables checked by Validate, a missing one is not a problem
+func tableKeys(cfg *config) []string {
+	keys := []string{}
+	for class := range cfg.Classes {
+		keys = append(keys, classKey(storeKey, class, cfg), classKey(openCycleKey, class, cfg))
+	}
+	return keys
+}
+
+// Tables of classes that may not be offset keep claims both ways
+func isGrossKey(key string, cfg *config) bool {
+	for class := range cfg.Classes {
+		if key == classKey(storeKey, class, cfg) || key == classKey(openCycleKey, class, cfg) {
+			return cfg.isGross(class)
+		}
+	}
+	return false
+}
+
+// args: -
+func (this smartContract) query_Validate(store Store, args []string) ([]byte, error) {
+	log.Debugf("queryValidate called with args: %s\n", args)
+
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+	problems, _, err := inspectTables(store, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	bts, err := json.Marshal(problems)
+	if err != nil {
+		log.Errorf("json.Marshal(problems) error: %s", err.Error())
+		return nil, err
+	}
+
+	return bts, nil
+}
+
+// Normalises the tables: missing counter parties are added, invalid claims dropped,
+// repeated ones added up and opposite ones netted, unless their class may not be offset. The problems found are returned.
+// args: -
+func (this smartContract) invoke_Repair(store Store, args []string) ([]byte, error) {
+	log.Debugf("invokeRepair called with args: %s\n", args)
+
+	// Check arguments
+	if err := this.checkAdmin(store); err != nil {
+		return nil, err
+	}
+	version, err := loadSchemaVersion(store)
+	checkCriticalError(err)
+	if version > schemaVersion {
+		message := fmt.Sprintf("state version %d is newer than %d of this chaincode\n", version, schemaVersion)
+		log.Errorf(message)
+		return nil, errors.New(message)
+	}
+
+	// Load existing data
+	cfg, err := loadConfig(store)
+	checkCriticalError(err)
+	problems, tables, err := inspectTables(store, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// All tables have the same counter parties
+	N := 0
+	for _, stored := range tables {
+		if n := stored.counterParties(); n > N {
+			N = n
+		}
+	}
+
+	// Save new data
+	for _, key := range tableKeys(cfg) {
+		stored, ok := tables[key]
+		if !ok {
+			continue
+		}
+		repaired := normalizeTable(stored.repair(N, isGrossKey(key, cfg)), cfg)
+		if err := saveTable(repaired, key, store); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := migrate(store); err != nil {
+		return nil, err
+	}
+
+	bts, err := json.Marshal(problems)
+	if err != nil {
+		log.Errorf("json.Marshal(problems) error: %s", err.Error())
+		return nil, err
+	}
+
+	return bts, nil
+}
+
+// Problems of the stored tables and the tables as far as they could be read.
+// A table that cannot be read at all is there without counter parties and claims.
+func inspectTables(store Store, cfg *config) ([]stateProblem, map[string]*tableBytes, error) {
+	problems := []stateProblem{}
+	tables := map[string]*tableBytes{}
+	for _, key := range tableKeys(cfg) {
+		bytes, err := store.GetState(key)
+		if err != nil {
+			log.Errorf("store.GetState(key) error: %s", err.Error())
+			return nil, nil, err
+		}
+		if len(bytes) == 0 {
+			continue
+		}
+
+		stored, err := decodeTable(bytes)
+		if err != nil {
+			problems = append(problems, stateProblem{Key: key, Problem: err.Error()})
+			tables[key] = &tableBytes{}
+			continue
+		}
+		for _, problem := range checkTable(stored, isGrossKey(key, cfg)) {
+			problems = append(problems, stateProblem{Key: key, Problem: problem})
+		}
+		tables[key] = stored
+	}
+
+	if main, open := tables[storeKey], tables[openCycleKey]; main != nil && open != nil &&
+		len(main.Nodes) != len(open.Nodes) {
+		problems = append(problems, stateProblem{Key: openCycleKey,
+			Problem: fmt.Sprintf("%d counter parties instead of %d", len(open.Nodes), len(main.Nodes))})
+	}
+	// Tables of the other classes get new counter parties when used
+	for _, key := range tableKeys(cfg)[2:] {
+		if main, other := tables[storeKey], tables[key]; main != nil && other != nil &&
+			len(other.Nodes) > len(main.Nodes) {
+			problems = append(problems, stateProblem{Key: key,
+				Problem: fmt.Sprintf("%d counter parties instead of at most %d", len(other.Nodes), len(main.Nodes))})
+		}
+	}
+
+	return problems, tables, nil
+}
+
+// What InitFromBytes would quietly drop or merge: counter parties are 0..N-1, each once,
+// claims are positive, between known counter parties, one per pair or, if gross, per direction.
+func checkTable(this *tableBytes, gross bool) []string {
+	problems := []string{}
+
+	N := len(this.Nodes)
+	seen := make([]bool, N)
+	for _, id := range this.Nodes {
+		switch {
+		case id < 0 || id >= N:
+			problems = append(problems, fmt.Sprintf("unexpected counter party %d", id))
+		case seen[id]:
+			problems = append(problems, fmt.Sprintf("repeated counter party %d", id))
+		default:
+			seen[id] = true
+		}
+	}
+	for id := range seen {
+		if !seen[id] {
+			problems = append(problems, fmt.Sprintf("missing counter party %d", id))
+		}
+	}
+
+	pairs := map[[2]int]bool{}
+	for _, c := range this.Edges {
+		switch {
+		case c.From < 0 || c.From >= N || c.To < 0 || c.To >= N:
+			problems = append(problems, fmt.Sprintf("claim %d -> %d of an unknown counter party", c.From, c.To))
+		case c.From == c.To:
+			problems = append(problems, fmt.Sprintf("claim of %d on itself", c.From))
+		case math.IsNaN(c.Value) || math.IsInf(c.Value, 0) || c.Value <= 0.0:
+			problems = append(problems, fmt.Sprintf("claim %d -> %d of %v is not positive", c.From, c.To, c.Value))
+		case pairs[[2]int{c.From, c.To}]:
+			problems = append(problems, fmt.Sprintf("repeated claim %d -> %d", c.From, c.To))
+		case !gross && pairs[[2]int{c.To, c.From}]:
+			problems = append(problems, fmt.Sprintf("claims both ways between %d and %d", c.From, c.To))
+		default:
+			pairs[[2]int{c.From, c.To}] = true
+		}
+	}
+
+	return problems
+}
+
+// Only for tables without problems
+func (this *tableBytes) toTable() Table {
+	return this.repair(len(this.Nodes), false)
+}
+
+func (this *tableBytes) toClassTable(gross bool) Table {
+	return this.repair(len(this.Nodes), gross)
+}
+
+// Every counter party up to the largest one there is
+func (this *tableBytes) counterParties() int {
+	N := 0
+	for _, id := range this.Nodes {
+		if id >= N {
+			N = id + 1
+		}
+	}
+	return N
+}
+
+// Gross, or dense or not by the claims there are
+func (this *tableBytes) repair(N int, gross bool) Table {
+	result := newTable(N, isDense(N, len(this.Edges)))
+	if gross {
+		result = NewGrossTable(N)
+	}
+	for _, c := range this.Edges {
+		if math.IsNaN(c.Value) || math.IsInf(c.Value, 0) {
+			continue
+		}
+		// The table ignores the rest: unknown counter parties, claims on self, not positive ones
+		result.AddClaim(c.From, c.To, c.Value)
+	}
+	return result
+}